@@ -19,6 +19,11 @@ type command struct {
 }
 
 func (c *command) process(char byte) {
+	if c.modChar == 'z' {
+		// The fold commands are all just a single character after the 'z'.
+		c.cmdChar = char
+		return
+	}
 	if char == '0' && c.motionCount == 0 {
 		c.motionChar1 = '0'
 	} else if char >= '0' && char <= '9' {
@@ -27,9 +32,9 @@ func (c *command) process(char byte) {
 	switch char {
 	case 'g', 'z':
 		c.modChar = char
-	case '.', 'u', 'I', 'S', 'o', 'O', 'C', 'A', 'x':
+	case '.', 'u', 'I', 'S', 'o', 'O', 'C', 'A', 'x', 'v', 'V':
 		c.cmdChar = char
-	case 'c', 'd', 'y':
+	case 'c', 'd', 'y', '>', '<':
 		if c.opChar == char {
 			c.motionChar1 = char
 		} else {
@@ -64,6 +69,8 @@ var motionChars = []byte("jkhl LHweWEb0$")
 
 func (c *command) hasMotion() bool {
 	return bytes.IndexByte(motionChars, c.motionChar1) != -1 ||
+		// Doubling a shift operator (like `>>`) shifts the current line.
+		((c.opChar == '>' || c.opChar == '<') && c.motionChar1 == c.opChar) ||
 		(c.motionChar2 != 0 && (c.motionChar1 == 'i' || c.motionChar1 == 'a'))
 }
 
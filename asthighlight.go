@@ -0,0 +1,592 @@
+package mdedit
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	extast "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// mdParser is the parser for both the editor's highlighting and the rendered document.
+var mdParser = newMarkdownParser()
+
+// newMarkdownParser returns a CommonMark parser along with the GFM extensions and the math
+// that the document renders.
+func newMarkdownParser() parser.Parser {
+	p := goldmark.DefaultParser()
+	p.AddOptions(
+		parser.WithBlockParsers(
+			util.Prioritized(extension.NewFootnoteBlockParser(), 999),
+			util.Prioritized(extension.NewDefinitionListParser(), 101),
+			util.Prioritized(extension.NewDefinitionDescriptionParser(), 102),
+			util.Prioritized(mathBlockParser{}, 650),
+		),
+		parser.WithParagraphTransformers(
+			util.Prioritized(extension.NewTableParagraphTransformer(), 200),
+		),
+		parser.WithInlineParsers(
+			util.Prioritized(extension.NewStrikethroughParser(), 500),
+			util.Prioritized(extension.NewTaskCheckBoxParser(), 0),
+			util.Prioritized(extension.NewFootnoteParser(), 101),
+			util.Prioritized(mathInlineParser{}, 150),
+			util.Prioritized(extension.NewTypographerParser(
+				extension.WithTypographicSubstitutions(typographicSubstitutions),
+			), 9999),
+		),
+		parser.WithASTTransformers(
+			util.Prioritized(extension.NewTableASTTransformer(), 0),
+			util.Prioritized(extension.NewFootnoteASTTransformer(), 999),
+		),
+	)
+	return p
+}
+
+// typographicSubstitutions are the characters that the typographer puts in place of plain
+// quotes, dashes and dots. The defaults are HTML entities, which the document doesn't decode.
+var typographicSubstitutions = map[extension.TypographicPunctuation][]byte{
+	extension.LeftSingleQuote:  []byte("‘"),
+	extension.RightSingleQuote: []byte("’"),
+	extension.LeftDoubleQuote:  []byte("“"),
+	extension.RightDoubleQuote: []byte("”"),
+	extension.EnDash:           []byte("–"),
+	extension.EmDash:           []byte("—"),
+	extension.Ellipsis:         []byte("…"),
+	extension.LeftAngleQuote:   []byte("«"),
+	extension.RightAngleQuote:  []byte("»"),
+	extension.Apostrophe:       []byte("’"),
+}
+
+// mdParse is a parsed markdown document.
+type mdParse struct {
+	src              []byte // the source, but with the front matter blanked out
+	root             ast.Node
+	frontMatter      []byte
+	frontMatterDelim byte
+}
+
+// parseMarkdown parses the given source. Any front matter is blanked out beforehand (rather
+// than being cut off) so that the offsets of the parsed nodes are still offsets into the
+// given source.
+func parseMarkdown(src []byte) *mdParse {
+	p := &mdParse{src: src}
+	if n, delim := splitFrontMatter(src); n > 0 {
+		p.src = blankFrontMatter(src, n)
+		p.frontMatter, p.frontMatterDelim = src[:n], delim
+	}
+	p.root = mdParser.Parse(text.NewReader(p.src))
+	return p
+}
+
+// astMaxLines is the most lines a text can have for its parse to be marked up for the
+// astHighlighter. Beyond that it only uses the line based highlighter.
+const astMaxLines = 5000
+
+// astLineMask is what the astHighlighter takes from the line based highlighter, which is
+// mostly what the parser doesn't know the positions of (tables and task checkboxes) or
+// doesn't parse at all, as well as bare URLs and link reference definitions (which the
+// parser doesn't leave in the tree).
+const astLineMask = mdTable | mdTask | mdTaskDone | mdFootnote | mdLinkText | mdLinkURL
+
+// astHighlighter derives the style marks from the nodes that goldmark parses out of the text,
+// which always agree with how the document gets rendered. Parsing is too slow to do on every
+// keystroke though, so the text is parsed in the background (see parsedMarks) and the marks
+// from the latest parse are used for whichever lines haven't changed since. The lines that
+// have are highlighted by the line based highlighter until the next parse.
+type astHighlighter struct {
+	lines  mdHighlighter
+	parsed [][]byte        // the lines of the text that was last parsed
+	marks  [][]mdStyleMark // the marks of each of the parsed lines
+}
+
+func (h *astHighlighter) highlight(buf *buffer) [][]mdStyleMark {
+	lineMarks := h.lines.highlight(buf)
+	if h.parsed == nil {
+		return lineMarks
+	}
+	n, prevN := len(buf.lines), len(h.parsed)
+	pre := 0
+	for pre < min(n, prevN) && bytes.Equal(buf.lines[pre].text, h.parsed[pre]) {
+		pre++
+	}
+	if pre == n && n == prevN {
+		return h.marks
+	}
+	suf := 0
+	for suf < min(n, prevN)-pre && bytes.Equal(buf.lines[n-1-suf].text, h.parsed[prevN-1-suf]) {
+		suf++
+	}
+	marks := make([][]mdStyleMark, n)
+	copy(marks, h.marks[:pre])
+	copy(marks[pre:], lineMarks[pre:n-suf])
+	copy(marks[n-suf:], h.marks[prevN-suf:])
+	return marks
+}
+
+// useParse sets the lines of a text that was parsed along with their marks, as returned by
+// parsedMarks. Without any lines, only the line based highlighter is used.
+func (h *astHighlighter) useParse(lines [][]byte, marks [][]mdStyleMark) {
+	h.parsed, h.marks = lines, marks
+}
+
+// parsedMarks returns the lines of the given text along with the style marks that its parse
+// comes up with for each of them, or nothing if it has more than astMaxLines lines.
+func parsedMarks(src []byte, doc *mdParse) (lines [][]byte, marks [][]mdStyleMark) {
+	lines = bytes.Split(src, []byte{'\n'})
+	if len(lines) > astMaxLines {
+		return nil, nil
+	}
+	var buf buffer
+	buf.lines = make([]line, len(lines))
+	for i := range lines {
+		buf.lines[i] = lineFromBytes(lines[i])
+	}
+	var h mdHighlighter
+	lineMarks := h.highlight(&buf)
+
+	m := astMarker{src: doc.src, lines: buf.lines}
+	m.starts = make([]int, len(buf.lines))
+	for i, off := 1, 0; i < len(buf.lines); i++ {
+		off += len(buf.lines[i-1].text) + 1
+		m.starts[i] = off
+	}
+	m.block(doc.root)
+	astMarks := m.styleMarks()
+
+	marks = make([][]mdStyleMark, len(lines))
+	for row := range marks {
+		marks[row] = mergeMarks(astMarks[row], lineMarks[row])
+	}
+	return lines, marks
+}
+
+// mergeMarks combines the marks that the parse came up with for a row with what's taken
+// from the line based highlighter's marks. The front matter is left entirely to the line
+// based highlighter since it isn't parsed, and nothing is taken from it within code or math.
+func mergeMarks(parsed, line []mdStyleMark) (marks []mdStyleMark) {
+	for _, m := range line {
+		if m.value&mdFrontMatter != 0 {
+			return line
+		}
+	}
+	var p, l int
+	var pv, lv uint32
+	for p < len(parsed) || l < len(line) {
+		col := -1
+		if p < len(parsed) {
+			col = parsed[p].col
+		}
+		if l < len(line) && (col == -1 || line[l].col < col) {
+			col = line[l].col
+		}
+		for p < len(parsed) && parsed[p].col == col {
+			pv = parsed[p].value
+			p++
+		}
+		for l < len(line) && line[l].col == col {
+			lv = line[l].value
+			l++
+		}
+		v := pv
+		if pv&(mdCodeSpan|mdCodeBlock|mdMath) == 0 {
+			v |= lv & astLineMask
+		}
+		switch n := len(marks); {
+		case n > 0 && marks[n-1].col == col:
+			marks[n-1].value = v
+		case n > 0 && marks[n-1].value == v, n == 0 && v == 0:
+		default:
+			marks = append(marks, mdStyleMark{col: col, value: v})
+		}
+	}
+	return marks
+}
+
+// astSpan is a range of the source (by byte offsets) that has a style.
+type astSpan struct {
+	start int
+	end   int
+	value uint32
+}
+
+// astMarker collects the styled spans of a parsed document.
+type astMarker struct {
+	src    []byte
+	lines  []line
+	starts []int // the offset of the start of each line
+	spans  []astSpan
+}
+
+func (m *astMarker) add(start, end int, value uint32) {
+	if end > start {
+		m.spans = append(m.spans, astSpan{start, end, value})
+	}
+}
+
+// rowOf returns the row that contains the given offset.
+func (m *astMarker) rowOf(off int) int {
+	return sort.Search(len(m.starts), func(i int) bool {
+		return m.starts[i] > off
+	}) - 1
+}
+
+// markRow styles the given row from the given column to its end.
+func (m *astMarker) markRow(row, col int, value uint32) {
+	if row < 0 || row >= len(m.lines) {
+		return
+	}
+	ln := m.lines[row].text
+	m.add(m.starts[row]+min(col, len(ln)), m.starts[row]+len(ln)+1, value)
+}
+
+// rowText returns the text of the given row without any indentation or blockquote markers.
+func (m *astMarker) rowText(row int) []byte {
+	return bytes.TrimLeft(m.lines[row].text, " \t>")
+}
+
+// rows returns the first and last rows that the given node's text is on.
+func (m *astMarker) rows(n ast.Node) (first, last int, ok bool) {
+	var start, stop int
+	add := func(s text.Segment) {
+		if !ok || s.Start < start {
+			start = s.Start
+		}
+		if !ok || s.Stop > stop {
+			stop = s.Stop
+		}
+		ok = true
+	}
+	_ = ast.Walk(n, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Text:
+			add(n.Segment)
+		case *ast.RawHTML:
+			for i := 0; i < n.Segments.Len(); i++ {
+				add(n.Segments.At(i))
+			}
+		case *ast.FencedCodeBlock:
+			if n.Info != nil {
+				add(n.Info.Segment)
+			}
+		}
+		if n.Type() == ast.TypeBlock {
+			for i := 0; i < n.Lines().Len(); i++ {
+				add(n.Lines().At(i))
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	if !ok {
+		return 0, 0, false
+	}
+	// The stop of a block's line is after its line break.
+	return m.rowOf(start), m.rowOf(max(start, stop-1)), true
+}
+
+// block marks the given block node and everything within it.
+func (m *astMarker) block(n ast.Node) {
+	switch n := n.(type) {
+	case *ast.Heading:
+		m.heading(n)
+	case *ast.Blockquote:
+		if first, last, ok := m.rows(n); ok {
+			for row := first; row <= last; row++ {
+				ln := m.lines[row].text
+				col := bytes.IndexByte(ln, '>')
+				if col == -1 {
+					col = len(ln) - len(bytes.TrimLeft(ln, " \t"))
+				}
+				m.markRow(row, col, mdBlockquote)
+			}
+		}
+	case *ast.FencedCodeBlock:
+		m.fencedCode(n)
+		return
+	case *ast.CodeBlock:
+		for i := 0; i < n.Lines().Len(); i++ {
+			m.markRow(m.rowOf(n.Lines().At(i).Start), 0, mdCodeBlock)
+		}
+		return
+	case *mathBlock:
+		m.mathBlock(n)
+		return
+	case *ast.ListItem:
+		if first, _, ok := m.rows(n); ok {
+			if lp := parseListPrefix(m.lines[first].text); lp.isListItem() {
+				m.add(m.starts[first]+lp.lead, m.starts[first]+lp.markerEnd, mdListMarker)
+			}
+		}
+	}
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		if c.Type() == ast.TypeInline {
+			m.inline(c)
+		} else {
+			m.block(c)
+		}
+	}
+}
+
+func (m *astMarker) heading(n *ast.Heading) {
+	first, last, ok := m.rows(n)
+	if !ok {
+		return
+	}
+	for row := first; row <= last; row++ {
+		ln := m.lines[row].text
+		m.markRow(row, len(ln)-len(m.rowText(row)), mdHeading)
+	}
+	// The parser doesn't tell an ATX heading from a setext one, but only a setext heading
+	// can be followed by an underline that isn't a part of it.
+	if u := last + 1; u < len(m.lines) && headingLevel(m.rowText(first)) == 0 && isSetextUnderline(m.rowText(u)) {
+		m.markRow(u, 0, mdSetextHeading)
+	}
+}
+
+// fencedCode marks a fenced code block (including its fences) along with the tokens of the
+// language that it names.
+func (m *astMarker) fencedCode(n *ast.FencedCodeBlock) {
+	lines := n.Lines()
+	var open int
+	switch {
+	case n.Info != nil:
+		open = m.rowOf(n.Info.Segment.Start)
+	case lines.Len() > 0:
+		open = m.rowOf(lines.At(0).Start) - 1
+	default:
+		// An empty block without an info string has nothing to go by other than the
+		// end of whatever came before it.
+		open = 0
+		for p := ast.Node(n); p != nil && open == 0; p = p.Parent() {
+			if prev := p.PreviousSibling(); prev != nil {
+				if _, last, ok := m.rows(prev); ok {
+					open = last + 1
+				}
+			}
+		}
+		for open < len(m.lines)-1 && openingFence(m.rowText(open)) == nil {
+			open++
+		}
+	}
+	if open < 0 || open >= len(m.lines) {
+		return
+	}
+	ln := m.lines[open].text
+	col := bytes.IndexAny(ln, "`~")
+	if col == -1 {
+		col = 0
+	}
+	last := open
+	if lines.Len() > 0 {
+		last = m.rowOf(max(lines.At(lines.Len()-1).Start, lines.At(lines.Len()-1).Stop-1))
+	}
+	if fence := openingFence(ln[col:]); fence != nil && last+1 < len(m.lines) &&
+		isClosingFence(m.rowText(last+1), fence) {
+		last++
+	}
+	for row := open; row <= last; row++ {
+		m.markRow(row, col, mdCodeBlock)
+	}
+
+	lang := codeLangFor(n.Language(m.src))
+	if lang == nil {
+		return
+	}
+	var st tokState
+	for i := 0; i < lines.Len(); i++ {
+		seg := lines.At(i)
+		code := bytes.TrimRight(seg.Value(m.src), "\r\n")
+		for _, tok := range lang.tokenize(code, &st) {
+			m.add(seg.Start+tok.start, seg.Start+tok.end, tokenMarks[tok.kind])
+		}
+	}
+}
+
+// mathBlock marks a block of math from its opening `$$` to its closing one, or to the end of
+// its last line if it isn't closed.
+func (m *astMarker) mathBlock(n *mathBlock) {
+	first, last := m.rowOf(n.open), m.rowOf(n.open)
+	switch lines := n.Lines(); {
+	case n.close != -1:
+		last = m.rowOf(n.close)
+	case lines.Len() > 0:
+		last = m.rowOf(max(lines.At(lines.Len()-1).Start, lines.At(lines.Len()-1).Stop-1))
+	}
+	m.add(n.open, n.open+2, mdMath)
+	for row := first; row <= last; row++ {
+		m.markRow(row, 0, mdMath)
+	}
+}
+
+// inline marks the given inline node and everything within it. It returns the range of the
+// source that the node spans (including any delimiters), or false if it can't be told.
+func (m *astMarker) inline(n ast.Node) (start, stop int, ok bool) {
+	switch n := n.(type) {
+	case *ast.Text:
+		return n.Segment.Start, n.Segment.Stop, true
+	case *ast.RawHTML:
+		if n.Segments.Len() == 0 {
+			return 0, 0, false
+		}
+		return n.Segments.At(0).Start, n.Segments.At(n.Segments.Len() - 1).Stop, true
+	case *ast.AutoLink:
+		// The label is a slice of the source, which gives away where it is.
+		label := n.Label(m.src)
+		start = cap(m.src) - cap(label) - 1
+		stop = start + len(label) + 2
+		m.add(start, stop, mdLinkURL)
+		return start, stop, true
+	case *mathInline:
+		start, stop = n.Segment.Start-n.delim(), n.Segment.Stop+n.delim()
+		m.add(start, stop, mdMath)
+		return start, stop, true
+	}
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		s, e, cok := m.inline(c)
+		if !cok {
+			continue
+		}
+		if !ok || s < start {
+			start = s
+		}
+		if !ok || e > stop {
+			stop = e
+		}
+		ok = true
+	}
+	if !ok {
+		return 0, 0, false
+	}
+	src := m.src
+	switch n := n.(type) {
+	case *ast.Emphasis:
+		start, stop = max(start-n.Level, 0), min(stop+n.Level, len(src))
+		if n.Level == 2 {
+			m.add(start, stop, mdStrong)
+		} else {
+			m.add(start, stop, mdItalic)
+		}
+	case *extast.Strikethrough:
+		start, stop = max(start-2, 0), min(stop+2, len(src))
+		m.add(start, stop, mdStrikethrough)
+	case *ast.CodeSpan:
+		// One space is stripped from either end of a code span's content.
+		if start > 0 && src[start-1] == ' ' {
+			start--
+		}
+		for start > 0 && src[start-1] == '`' {
+			start--
+		}
+		if stop < len(src) && src[stop] == ' ' {
+			stop++
+		}
+		for stop < len(src) && src[stop] == '`' {
+			stop++
+		}
+		m.add(start, stop, mdCodeSpan)
+	case *ast.Link:
+		start, stop = m.link(start, stop, mdLinkText)
+	case *ast.Image:
+		start, stop = m.link(start, stop, mdLinkText|mdItalic)
+		if start > 0 && src[start-1] == '!' {
+			start--
+			m.add(start, start+1, mdLinkText|mdItalic)
+		}
+	}
+	return start, stop, true
+}
+
+// link marks a link whose text spans the given range, and returns the range that the whole
+// link spans (from its opening bracket to the end of its destination or reference).
+func (m *astMarker) link(start, stop int, textValue uint32) (int, int) {
+	src := m.src
+	if i := bytes.LastIndexByte(src[:start], '['); i != -1 {
+		start = i
+	}
+	if i := bytes.IndexByte(src[stop:], ']'); i != -1 {
+		stop += i + 1
+	}
+	m.add(start, stop, textValue)
+	if stop == len(src) {
+		return start, stop
+	}
+	end := -1
+	switch src[stop] {
+	case '(':
+		end = closingEnd(src, stop, '(', ')')
+	case '[':
+		end = closingEnd(src, stop, '[', ']')
+	}
+	if end != -1 {
+		m.add(stop, end, mdLinkURL)
+		stop = end
+	}
+	return start, stop
+}
+
+// styleMarks turns the collected spans into the style marks of each row.
+func (m *astMarker) styleMarks() [][]mdStyleMark {
+	type event struct {
+		off   int
+		value uint32
+		open  bool
+	}
+	events := make([]event, 0, len(m.spans)*2)
+	for _, s := range m.spans {
+		events = append(events, event{s.start, s.value, true}, event{s.end, s.value, false})
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].off < events[j].off
+	})
+	// The spans can overlap (even ones with the same styles), so each style is on as long
+	// as any span with it is.
+	var counts [32]int
+	value := func() (v uint32) {
+		for bit := range counts {
+			if counts[bit] > 0 {
+				v |= 1 << bit
+			}
+		}
+		return v
+	}
+	marks := make([][]mdStyleMark, len(m.lines))
+	ev := 0
+	for row := range m.lines {
+		var rowMarks []mdStyleMark
+		if v := value(); v != 0 {
+			rowMarks = append(rowMarks, mdStyleMark{col: 0, value: v})
+		}
+		for ev < len(events) && (row == len(m.lines)-1 || events[ev].off < m.starts[row+1]) {
+			off := events[ev].off
+			for ; ev < len(events) && events[ev].off == off; ev++ {
+				for bit := range counts {
+					if events[ev].value&(1<<bit) == 0 {
+						continue
+					}
+					if events[ev].open {
+						counts[bit]++
+					} else {
+						counts[bit]--
+					}
+				}
+			}
+			col, v := off-m.starts[row], value()
+			switch n := len(rowMarks); {
+			case n > 0 && rowMarks[n-1].col == col:
+				rowMarks[n-1].value = v
+			case n > 0 && rowMarks[n-1].value == v, n == 0 && v == 0:
+			default:
+				rowMarks = append(rowMarks, mdStyleMark{col: col, value: v})
+			}
+		}
+		marks[row] = rowMarks
+	}
+	return marks
+}
@@ -0,0 +1,197 @@
+package mdedit
+
+import "bytes"
+
+// AutoPairs is a set of delimiters that get automatically closed when their opening half is
+// typed in insert mode, and that wrap the selection when typed in visual mode.
+type AutoPairs uint8
+
+const (
+	AutoPairParen      AutoPairs = 1 << iota // ( )
+	AutoPairBracket                          // [ ]
+	AutoPairBrace                            // { }
+	AutoPairQuote                            // " "
+	AutoPairBacktick                         // ` ` (and ``` which opens a fenced code block)
+	AutoPairStrong                           // ** **
+	AutoPairUnderscore                       // _ _
+
+	AutoPairAll = AutoPairParen | AutoPairBracket | AutoPairBrace | AutoPairQuote |
+		AutoPairBacktick | AutoPairStrong | AutoPairUnderscore
+)
+
+type autoPair struct {
+	flag  AutoPairs
+	open  string
+	close string
+}
+
+var autoPairDelims = []autoPair{
+	{AutoPairParen, "(", ")"},
+	{AutoPairBracket, "[", "]"},
+	{AutoPairBrace, "{", "}"},
+	{AutoPairQuote, `"`, `"`},
+	{AutoPairBacktick, "`", "`"},
+	{AutoPairStrong, "**", "**"},
+	{AutoPairUnderscore, "_", "_"},
+}
+
+// autoPairFor returns the enabled delimiter pair whose opening half ends with the given
+// character (if there is one).
+func (ap AutoPairs) autoPairFor(char byte) (autoPair, bool) {
+	for _, p := range autoPairDelims {
+		if ap&p.flag != 0 && p.open[len(p.open)-1] == char {
+			return p, true
+		}
+	}
+	return autoPair{}, false
+}
+
+// autoClose is a closing delimiter that was automatically inserted at a column on the
+// current line.
+type autoClose struct {
+	col   int
+	open  string
+	close string
+}
+
+// typePaired handles the typing of the given character in insert mode with regards to auto
+// pairs, returning false if the character should just be inserted as is. Typing the closing
+// half of a delimiter that was automatically inserted steps over it.
+func (ed *Editor) typePaired(char byte) bool {
+	ln := ed.buf.currentLine()
+	col := ed.buf.cursor.col
+	if n := len(ed.autoClosed); n > 0 {
+		ac := &ed.autoClosed[n-1]
+		if ac.col == col && ac.close[0] == char && ln.charAt(col) == char {
+			ed.buf.cursorRight()
+			ed.buf.prefCol = ed.buf.cursor.col
+			ac.col++
+			ac.close = ac.close[1:]
+			if ac.close == "" {
+				ed.autoClosed = ed.autoClosed[:n-1]
+			}
+			return true
+		}
+	}
+	p, ok := ed.AutoPairs.autoPairFor(char)
+	if !ok {
+		return false
+	}
+	prev, next := ln.charAt(col-1), ln.charAt(col)
+	if isWordChar(next) {
+		return false
+	}
+	switch char {
+	case '`':
+		if start := ln.startingIndex(); col == start+2 && col == len(ln.text) && bytes.HasSuffix(ln.text, []byte("``")) {
+			ed.buf.insert("`")
+			ed.buf.openFence(start)
+			ed.autoClosed = ed.autoClosed[:0]
+			return true
+		}
+		if prev == '`' {
+			return false
+		}
+	case '*':
+		// Only the second asterisk of an opening `**` gets paired, which means the one
+		// before it can't be following a word (in which case it'd be closing).
+		if prev != '*' || ln.charAtIs(col-2, '*') || isWordChar(ln.charAt(col-2)) {
+			return false
+		}
+	case '"', '_':
+		if isWordChar(prev) {
+			return false
+		}
+	}
+	txt := p.open[len(p.open)-1:] + p.close
+	ed.buf.insert(txt)
+	ed.buf.cursor.col -= len(p.close)
+	ed.buf.prefCol = ed.buf.cursor.col
+	ed.shiftAutoClosed(len(txt))
+	ed.autoClosed = append(ed.autoClosed, autoClose{
+		col:   ed.buf.cursor.col,
+		open:  p.open,
+		close: p.close,
+	})
+	return true
+}
+
+// deletePair deletes both halves of the innermost auto pair if the cursor is right in
+// between them (i.e. the pair is empty). It returns false if there is no such pair.
+func (ed *Editor) deletePair() bool {
+	n := len(ed.autoClosed)
+	if n == 0 {
+		return false
+	}
+	ac := ed.autoClosed[n-1]
+	ln := ed.buf.currentLine()
+	col := ed.buf.cursor.col
+	if ac.col != col || !bytes.HasPrefix(ln.text[col:], []byte(ac.close)) || !bytes.HasSuffix(ln.text[:col], []byte(ac.open)) {
+		return false
+	}
+	ln.deleteRange(col-len(ac.open), col+len(ac.close))
+	ed.buf.cursor.col -= len(ac.open)
+	ed.buf.prefCol = ed.buf.cursor.col
+	ed.autoClosed = ed.autoClosed[:n-1]
+	ed.shiftAutoClosed(-len(ac.open) - len(ac.close))
+	return true
+}
+
+// shiftAutoClosed moves the columns of all automatically inserted closing delimiters by
+// the given amount, which is needed when text is inserted or deleted in front of them.
+func (ed *Editor) shiftAutoClosed(n int) {
+	for i := range ed.autoClosed {
+		ed.autoClosed[i].col += n
+	}
+}
+
+// deleteForwardAutoClosed accounts for the character under the cursor being deleted, which
+// is either in front of the automatically inserted closing delimiters or the start of one,
+// which then isn't kept track of anymore.
+func (ed *Editor) deleteForwardAutoClosed() {
+	col := ed.buf.cursor.col
+	if col >= ed.buf.currLineLen() {
+		// The next line gets joined onto this one, behind all of them.
+		return
+	}
+	kept := ed.autoClosed[:0]
+	for _, ac := range ed.autoClosed {
+		if ac.col == col {
+			continue
+		}
+		if ac.col > col {
+			ac.col--
+		}
+		kept = append(kept, ac)
+	}
+	ed.autoClosed = kept
+}
+
+// wrapSelection surrounds the visual mode selection with the enabled delimiter pair for
+// the given character, returning false if there isn't one. Wrapping a linewise selection
+// with a backtick puts those lines in a fenced code block.
+func (ed *Editor) wrapSelection(char byte) bool {
+	p, ok := ed.AutoPairs.autoPairFor(char)
+	if !ok {
+		return false
+	}
+	start, end := ed.selection()
+	if ed.mode == modeVisualLine && char == '`' {
+		indent := ed.buf.lines[start.row].text[:start.col]
+		fence := append(append([]byte{}, indent...), "```"...)
+		ed.buf.insertLines(end.row+1, fence)
+		ed.buf.insertLines(start.row, fence)
+	} else {
+		ed.buf.insertAt(end, []byte(p.close))
+		ed.buf.insertAt(start, []byte(p.open))
+	}
+	ed.buf.cursor = start
+	ed.buf.prefCol = start.col
+	ed.mode = modeNormal
+	return true
+}
+
+func isWordChar(char byte) bool {
+	return char == '_' || char >= 0x80 ||
+		(char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z') || (char >= '0' && char <= '9')
+}
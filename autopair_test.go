@@ -0,0 +1,42 @@
+package mdedit
+
+import (
+	"testing"
+
+	"gioui.org/io/event"
+	"gioui.org/io/key"
+	"gioui.org/layout"
+)
+
+// typingQueue hands out the events of typing some text, with a key press for each letter
+// (as it's in the editor's key set) followed by the text it inserts.
+type typingQueue []event.Event
+
+func (q typingQueue) Events(event.Tag) []event.Event { return q }
+
+func typing(txt string) typingQueue {
+	var q typingQueue
+	for _, r := range txt {
+		if r >= 'a' && r <= 'z' {
+			q = append(q, key.Event{Name: string(r - 'a' + 'A'), State: key.Press})
+		}
+		q = append(q, key.EditEvent{Text: string(r)})
+	}
+	return q
+}
+
+func TestTypeAutoPairs(t *testing.T) {
+	for txt, want := range map[string]string{
+		"(abc)":   "(abc)",
+		"[a(b)c]": "[a(b)c]",
+		`"ab"`:    `"ab"`,
+		"(ab":     "(ab)",
+	} {
+		ed := &Editor{AutoPairs: AutoPairAll, mode: modeInsert}
+		ed.SetText(nil)
+		ed.processInsertEvents(layout.Context{Queue: typing(txt)})
+		if got := string(ed.Text()); got != want {
+			t.Errorf("typing %q: got %q, want %q", txt, got, want)
+		}
+	}
+}
@@ -9,6 +9,10 @@ type buffer struct {
 	// cursor should be on (or as close to) the 2nd character of each of those lines. A
 	// value of `-1` indicates "end of the line."
 	prefCol int
+	// cursors are any additional cursors, which all get the same edits as the main one.
+	cursors []extraCursor
+	// folds are the first rows of any closed folds.
+	folds map[int]bool
 }
 
 type line struct {
@@ -145,6 +149,61 @@ func (b *buffer) insertNewLine() {
 	b.lines[b.cursor.row] = trunced
 }
 
+// insertContinuedNewLine is like insertNewLine, except that the new line continues any
+// list item or blockquote that the cursor is on. If the item has no content though, its
+// marker is removed instead (which is how a list or blockquote is ended).
+func (b *buffer) insertContinuedNewLine() {
+	ln := b.currentLine()
+	lp := parseListPrefix(ln.text)
+	if !lp.continues() || b.cursor.col < lp.width {
+		b.insertNewLine()
+		return
+	}
+	if isSpace(ln.text[lp.width:]...) {
+		keep := 0
+		if lp.isListItem() && lp.quoted {
+			keep = lp.lead
+		}
+		ln.text = ln.text[:keep]
+		b.cursor.col = keep
+		b.prefCol = keep
+		// Any items after the removed one take its place in the numbering.
+		lp.number--
+		b.renumberList(b.cursor.row, lp)
+		return
+	}
+	pfx := lp.continuation(ln.text, 1)
+	b.insertNewLine()
+	b.insert(string(pfx))
+	lp.number++
+	b.renumberList(b.cursor.row, lp)
+}
+
+// insertAt inserts the given text at the given position without moving the cursor.
+func (b *buffer) insertAt(p position, txt []byte) {
+	ln := &b.lines[p.row]
+	ln.text = append(ln.text[:p.col], append(append([]byte{}, txt...), ln.text[p.col:]...)...)
+}
+
+// insertLines inserts new lines with the given texts before the given row.
+func (b *buffer) insertLines(row int, texts ...[]byte) {
+	lns := make([]line, len(texts))
+	for i, txt := range texts {
+		lns[i] = lineFromBytes(txt)
+	}
+	b.lines = append(b.lines[:row], append(lns, b.lines[row:]...)...)
+}
+
+// openFence closes the fenced code block that the current line opens, and puts the cursor
+// on an empty line in between. The given indentation is used for all of the new lines.
+func (b *buffer) openFence(indent int) {
+	pfx := b.currentLine().text[:indent]
+	b.insertLines(b.cursor.row+1, pfx, append(append([]byte{}, pfx...), "```"...))
+	b.cursor.row++
+	b.cursor.col = indent
+	b.prefCol = indent
+}
+
 func (b *buffer) insert(txt string) {
 	ln := b.currentLine()
 	col := b.cursor.col
@@ -161,13 +220,23 @@ func (b *buffer) mvCursorIntoView() {
 }
 
 func (b *buffer) mvViewIntoCursor() {
+	folds := b.closedFolds()
 	y := b.cursor.row
-	bot := b.vision.y + b.vision.h - 1
-	switch {
-	case y < b.vision.y:
+	if f, ok := foldAt(folds, y); ok {
+		y = f.start
+	}
+	if y < b.vision.y {
 		b.vision.y = y
-	case y > bot:
-		b.vision.y += y - bot
+		return
+	}
+	// Count the rows that are displayed from the top of the view down to the cursor, and
+	// scroll down by however many of them don't fit.
+	n := 1
+	for row := b.vision.y; row < y; n++ {
+		row, _ = b.rowBelow(folds, row)
+	}
+	for ; n > b.vision.h && b.vision.y < y; n-- {
+		b.vision.y, _ = b.rowBelow(folds, b.vision.y)
 	}
 }
 
@@ -212,12 +281,25 @@ func (b *buffer) setCursorCol(v int) {
 }
 
 func (b *buffer) startNewLine(below bool) {
+	// Continue any list item or blockquote that the current line is a part of.
+	var pfx []byte
+	ln := b.currentLine()
+	lp := parseListPrefix(ln.text)
+	if lp.continues() {
+		numOffset := 0
+		if below {
+			numOffset = 1
+		}
+		pfx = lp.continuation(ln.text, numOffset)
+	}
 	b.lines = append(b.lines[:b.cursor.row+1], b.lines[b.cursor.row:]...)
-	b.cursor.col = 0
+	b.cursor.col = len(pfx)
+	b.prefCol = b.cursor.col
 	if below {
 		b.cursor.row++
 	}
-	b.lines[b.cursor.row] = line{}
+	b.lines[b.cursor.row] = line{text: pfx}
+	b.renumberList(b.cursor.row, parseListPrefix(pfx))
 }
 
 func (b *buffer) text() (txt []byte) {
@@ -281,14 +363,15 @@ func (ln *line) startingIndex() (start int) {
 }
 
 func (ln *line) toggleCheckItem() {
-	col := ln.startingIndex() + 2
-	if ln.charAtIs(col, '[') && ln.charAtIs(col+2, ']') {
-		switch ln.text[col+1] {
-		case 'x':
-			ln.text[col+1] = ' '
-		case ' ':
-			ln.text[col+1] = 'x'
-		}
+	lp := parseListPrefix(ln.text)
+	if !lp.task {
+		return
+	}
+	switch col := lp.body + 1; ln.text[col] {
+	case 'x', 'X':
+		ln.text[col] = ' '
+	case ' ':
+		ln.text[col] = 'x'
 	}
 }
 
@@ -305,6 +388,7 @@ type iter struct {
 	row     int
 	col     int
 	prefCol int
+	folds   []foldRange // the closed folds, which are skipped over
 }
 
 func newIter(b *buffer) iter {
@@ -313,6 +397,7 @@ func newIter(b *buffer) iter {
 		row:     b.cursor.row,
 		col:     b.cursor.col,
 		prefCol: b.prefCol,
+		folds:   b.closedFolds(),
 	}
 }
 
@@ -330,13 +415,13 @@ func (it *iter) next() bool {
 		ceilX++
 	}
 	if it.col > ceilX {
-		lineCount := len(it.buf.lines)
-		if it.row >= lineCount-1 {
+		row, ok := it.buf.rowBelow(it.folds, it.row)
+		if !ok {
 			it.col--
 			return false
 		}
 		it.col = 0
-		it.row++
+		it.row = row
 	}
 	return true
 }
@@ -344,11 +429,12 @@ func (it *iter) next() bool {
 func (it *iter) prev() bool {
 	it.col--
 	if it.col < 0 {
-		if it.row == 0 {
+		row, ok := it.buf.rowAbove(it.folds, it.row)
+		if !ok {
 			it.col++
 			return false
 		}
-		it.row--
+		it.row = row
 		lnLen := len(it.buf.lines[it.row].text)
 		it.col = max(0, lnLen-1)
 	}
@@ -356,14 +442,14 @@ func (it *iter) prev() bool {
 }
 
 func (it *iter) seekNthLineFromTop(count int) {
-	it.row = min(it.buf.vision.y+count, len(it.buf.lines)-1)
-	it.ensureX()
+	it.row = it.buf.vision.y
+	it.seekByY(count)
 }
 
 func (it *iter) seekNthLineFromBot(count int) {
-	bot := min(it.buf.vision.y+it.buf.vision.h-1, len(it.buf.lines)-1)
-	it.row = bot - count
-	it.ensureX()
+	it.row = it.buf.vision.y
+	it.seekByY(it.buf.vision.h - 1)
+	it.seekByY(0 - count)
 }
 
 func (it *iter) seekByX(inc int) {
@@ -382,9 +468,13 @@ func (it *iter) seekByX(inc int) {
 }
 
 func (it *iter) seekByY(inc int) {
-	target := it.row + inc
-	ceil := len(it.buf.lines) - 1
-	it.row = max(min(target, ceil), 0)
+	ok := true
+	for ; inc > 0 && ok; inc-- {
+		it.row, ok = it.buf.rowBelow(it.folds, it.row)
+	}
+	for ; inc < 0 && ok; inc++ {
+		it.row, ok = it.buf.rowAbove(it.folds, it.row)
+	}
 	it.ensureX()
 }
 
@@ -446,6 +536,10 @@ func (p position) is(row, col int) bool {
 	return p.row == row && p.col == col
 }
 
+func (p position) before(q position) bool {
+	return p.row < q.row || (p.row == q.row && p.col < q.col)
+}
+
 func min(a, b int) int {
 	if a < b {
 		return a
@@ -0,0 +1,232 @@
+package mdedit
+
+import (
+	"bytes"
+	"image"
+	"sort"
+)
+
+// extraCursor is an additional cursor (besides the buffer's main one), which is used for
+// making the same edit in multiple places at once.
+type extraCursor struct {
+	pos     position
+	prefCol int
+}
+
+// eachCursor calls fn once for each cursor, with the buffer's main cursor set to it. The
+// cursors are visited from the bottom of the buffer up. Since edits happen at (or right
+// around) the cursor, only the cursors that were already visited (the ones after it) have
+// to be moved by however much the buffer grew or shrunk. Cursors that end up at the same
+// position are merged.
+func (b *buffer) eachCursor(fn func()) {
+	if len(b.cursors) == 0 {
+		fn()
+		return
+	}
+	type visit struct {
+		cur  extraCursor
+		off  int
+		main bool
+	}
+	visits := make([]visit, 0, len(b.cursors)+1)
+	visits = append(visits, visit{cur: extraCursor{b.cursor, b.prefCol}, main: true})
+	for _, c := range b.cursors {
+		visits = append(visits, visit{cur: c})
+	}
+	sort.SliceStable(visits, func(i, j int) bool {
+		return visits[j].cur.pos.before(visits[i].cur.pos)
+	})
+	for i := range visits {
+		v := &visits[i]
+		b.cursor, b.prefCol = v.cur.pos, v.cur.prefCol
+		size := b.size()
+		fn()
+		delta := b.size() - size
+		v.cur.prefCol = b.prefCol
+		v.off = b.offset(b.cursor)
+		for j := 0; j < i; j++ {
+			visits[j].off += delta
+		}
+	}
+	b.cursors = b.cursors[:0]
+	seen := make(map[position]bool, len(visits))
+	for _, v := range visits {
+		if v.main {
+			b.cursor, b.prefCol = b.positionAt(v.off), v.cur.prefCol
+			seen[b.cursor] = true
+		}
+	}
+	for i := len(visits) - 1; i >= 0; i-- {
+		v := visits[i]
+		if p := b.positionAt(v.off); !v.main && !seen[p] {
+			seen[p] = true
+			b.cursors = append(b.cursors, extraCursor{p, v.cur.prefCol})
+		}
+	}
+}
+
+// addCursor adds an extra cursor at the given position (unless one is already there).
+func (b *buffer) addCursor(p position, prefCol int) {
+	if b.cursor == p {
+		return
+	}
+	for _, c := range b.cursors {
+		if c.pos == p {
+			return
+		}
+	}
+	b.cursors = append(b.cursors, extraCursor{p, prefCol})
+}
+
+// addCursorVertically adds a cursor on the line above the topmost cursor (or below the
+// bottommost one), as close to its preferred column as possible.
+func (b *buffer) addCursorVertically(down bool) {
+	edge := extraCursor{b.cursor, b.prefCol}
+	for _, c := range b.cursors {
+		if (down && edge.pos.before(c.pos)) || (!down && c.pos.before(edge.pos)) {
+			edge = c
+		}
+	}
+	it := iter{buf: b, row: edge.pos.row, col: edge.pos.col, prefCol: edge.prefCol, folds: b.closedFolds()}
+	if down {
+		it.seekByY(1)
+	} else {
+		it.seekByY(-1)
+	}
+	b.addCursor(it.position(), edge.prefCol)
+}
+
+// cursorCols returns the columns of all of the cursors on the given row.
+func (b *buffer) cursorCols(row int) (cols []int) {
+	if b.cursor.row == row {
+		cols = append(cols, b.cursor.col)
+	}
+	for _, c := range b.cursors {
+		if c.pos.row == row {
+			cols = append(cols, c.pos.col)
+		}
+	}
+	return cols
+}
+
+// matches returns the positions of all occurrences of the given text. If wholeWord is
+// true, occurrences that are part of a larger word are skipped.
+func (b *buffer) matches(txt []byte, wholeWord bool) (pp []position) {
+	if len(txt) == 0 {
+		return nil
+	}
+	for row := range b.lines {
+		ln := &b.lines[row]
+		for col := 0; col+len(txt) <= len(ln.text); {
+			i := bytes.Index(ln.text[col:], txt)
+			if i == -1 {
+				break
+			}
+			col += i
+			if !wholeWord || (!isWordChar(ln.charAt(col-1)) && !isWordChar(ln.charAt(col+len(txt)))) {
+				pp = append(pp, position{row, col})
+			}
+			col += len(txt)
+		}
+	}
+	return pp
+}
+
+// wordAt returns the bounds of the word at the given position.
+func (ln *line) wordAt(col int) (int, int, bool) {
+	if !isWordChar(ln.charAt(col)) {
+		return 0, 0, false
+	}
+	start, end := col, col
+	for start > 0 && isWordChar(ln.text[start-1]) {
+		start--
+	}
+	for end < len(ln.text) && isWordChar(ln.text[end]) {
+		end++
+	}
+	return start, end, true
+}
+
+func (b *buffer) offset(p position) (off int) {
+	for row := 0; row < p.row; row++ {
+		off += len(b.lines[row].text) + 1
+	}
+	return off + p.col
+}
+
+func (b *buffer) positionAt(off int) position {
+	for row := range b.lines {
+		n := len(b.lines[row].text)
+		if off <= n || row == len(b.lines)-1 {
+			return position{row: row, col: max(0, min(off, n))}
+		}
+		off -= n + 1
+	}
+	return position{}
+}
+
+func (b *buffer) size() (n int) {
+	for i := range b.lines {
+		n += len(b.lines[i].text) + 1
+	}
+	return n
+}
+
+// addCursorsAtMatches adds a cursor at every occurrence of the word under the cursor (or,
+// in visual mode, of the selected text) and marks the lines they're on in the search sign
+// column.
+func (ed *Editor) addCursorsAtMatches() {
+	var (
+		txt       []byte
+		wholeWord bool
+		start     = ed.buf.cursor
+	)
+	switch ed.mode {
+	case modeNormal:
+		ln := ed.buf.currentLine()
+		c1, c2, ok := ln.wordAt(ed.buf.cursor.col)
+		if !ok {
+			return
+		}
+		txt, wholeWord = ln.text[c1:c2], true
+		start.col = c1
+	case modeVisual:
+		var end position
+		start, end = ed.selection()
+		if start.row != end.row {
+			return
+		}
+		txt = ed.buf.lines[start.row].text[start.col:end.col]
+		ed.mode = modeNormal
+	default:
+		return
+	}
+	txt = append([]byte{}, txt...)
+	ed.buf.cursor = start
+	ed.buf.prefCol = start.col
+	ed.ClearSigns(SignColumnSearch)
+	for _, p := range ed.buf.matches(txt, wholeWord) {
+		ed.buf.addCursor(p, p.col)
+		ed.SetSign(SignColumnSearch, p.row, Sign{Text: "»", Color: ed.palette.ListMarker})
+	}
+}
+
+// addCursorAtPoint adds a cursor at the character under the given point (relative to
+// where the editor's lines are drawn).
+func (ed *Editor) addCursorAtPoint(pt image.Point) {
+	if ed.lnHeight == 0 || ed.charWidth == 0 {
+		return
+	}
+	it := iter{buf: &ed.buf, row: ed.buf.vision.y, folds: ed.buf.closedFolds()}
+	it.seekByY(pt.Y / ed.lnHeight)
+	row := it.row
+	col := max(0, (pt.X-ed.gutterWidth())/ed.charWidth)
+	col = min(col, max(0, len(ed.buf.lines[row].text)-1))
+	ed.buf.addCursor(position{row, col}, col)
+}
+
+// clearCursors removes all extra cursors along with any search signs.
+func (ed *Editor) clearCursors() {
+	ed.buf.cursors = nil
+	ed.ClearSigns(SignColumnSearch)
+}
@@ -0,0 +1,207 @@
+package mdedit
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+
+	"gioui.org/f32"
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"gioui.org/text"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	"github.com/yuin/goldmark/ast"
+)
+
+// isDiagram is a fenced code block of a diagram's source, which is laid out as the diagram
+// rather than as code.
+type isDiagram struct {
+	diagram diagram
+}
+
+// diagram is a diagram that the document can draw.
+type diagram interface {
+	layout(gtx C, th *material.Theme) D
+}
+
+// diagramParsers are the parsers of the diagrams for each fenced code block info string.
+var diagramParsers = map[string]func(string) (diagram, error){
+	"dot":      parseDOT,
+	"graphviz": parseDOT,
+	"sequence": parseSequence,
+}
+
+// parseDiagram parses the source of a fenced code block as a diagram if its info string names
+// a kind of diagram. It returns nil (and no error) if it doesn't.
+func parseDiagram(src []byte, n *ast.FencedCodeBlock) (diagram, error) {
+	parse, ok := diagramParsers[strings.ToLower(string(n.Language(src)))]
+	if !ok {
+		return nil, nil
+	}
+	var b bytes.Buffer
+	for i := 0; i < n.Lines().Len(); i++ {
+		seg := n.Lines().At(i)
+		b.Write(seg.Value(src))
+	}
+	return parse(b.String())
+}
+
+// layDiagram lays out a diagram centered within the width, or scrolling sideways if it's
+// wider than that.
+func layDiagram(gtx C, th *material.Theme, dgm isDiagram, st *blockState) D {
+	m := op.Record(gtx.Ops)
+	dims := dgm.diagram.layout(gtx, th)
+	call := m.Stop()
+	width := gtx.Constraints.Max.X
+	if st.scroll.Axis != layout.Horizontal {
+		st.scroll.Axis = layout.Horizontal
+	}
+	return material.List(th, &st.scroll).Layout(gtx, 1, func(gtx C, _ int) D {
+		x := max(0, (width-dims.Size.X)/2)
+		defer op.Offset(image.Point{X: x}).Push(gtx.Ops).Pop()
+		call.Add(gtx.Ops)
+		return D{Size: image.Point{x + dims.Size.X, dims.Size.Y}}
+	})
+}
+
+// diagramPen draws the shapes and text that diagrams are made of.
+type diagramPen struct {
+	shaper text.Shaper
+	size   unit.Sp
+	fg     color.NRGBA
+	fill   color.NRGBA // the color within shapes
+	bg     color.NRGBA
+}
+
+func newDiagramPen(th *material.Theme) diagramPen {
+	return diagramPen{
+		shaper: th.Shaper,
+		size:   th.TextSize * 0.9,
+		fg:     th.Fg,
+		fill:   color.NRGBA{120, 140, 200, 40},
+		bg:     th.Bg,
+	}
+}
+
+// diagramText is a laid out piece of text that's yet to be drawn.
+type diagramText struct {
+	call op.CallOp
+	size image.Point
+}
+
+// text lays out the given text (which can have more than one line) centered on itself.
+func (p diagramPen) text(gtx C, txt string) diagramText {
+	m := op.Record(gtx.Ops)
+	paint.ColorOp{Color: p.fg}.Add(gtx.Ops)
+	gtx.Constraints = layout.Constraints{Max: image.Point{1 << 24, 1 << 24}}
+	l := widget.Label{Alignment: text.Middle}
+	dims := l.Layout(gtx, p.shaper, text.Font{}, p.size, txt)
+	return diagramText{call: m.Stop(), size: dims.Size}
+}
+
+// drawText draws text centered on the given point, over a patch of the background if asked
+// to (so that it can be read over lines).
+func (p diagramPen) drawText(gtx C, t diagramText, center image.Point, onBg bool) {
+	corner := center.Sub(t.size.Div(2))
+	if onBg {
+		paint.FillShape(gtx.Ops, p.bg, clip.Rect{Min: corner, Max: corner.Add(t.size)}.Op())
+	}
+	defer op.Offset(corner).Push(gtx.Ops).Pop()
+	t.call.Add(gtx.Ops)
+}
+
+func (p diagramPen) strokeWidth(gtx C) float32 {
+	return float32(max(1, gtx.Dp(1)))
+}
+
+// line draws a straight line, made up of dashes if asked to.
+func (p diagramPen) line(gtx C, from, to f32.Point, dashed bool) {
+	var path clip.Path
+	path.Begin(gtx.Ops)
+	if !dashed {
+		path.MoveTo(from)
+		path.LineTo(to)
+	} else {
+		d := to.Sub(from)
+		length := float32(math.Hypot(float64(d.X), float64(d.Y)))
+		dash, gap := float32(gtx.Dp(6)), float32(gtx.Dp(4))
+		for at := float32(0); at < length; at += dash + gap {
+			path.MoveTo(from.Add(d.Mul(at / length)))
+			path.LineTo(from.Add(d.Mul(min32(at+dash, length) / length)))
+		}
+	}
+	paint.FillShape(gtx.Ops, p.fg, clip.Stroke{Path: path.End(), Width: p.strokeWidth(gtx)}.Op())
+}
+
+// arrowHead draws the head of an arrow that points at tip from the direction of from. An
+// open head is just two lines rather than a filled triangle.
+func (p diagramPen) arrowHead(gtx C, tip, from f32.Point, open bool) {
+	d := tip.Sub(from)
+	length := float32(math.Hypot(float64(d.X), float64(d.Y)))
+	if length == 0 {
+		return
+	}
+	size := float32(gtx.Dp(9))
+	d = d.Mul(size / length)
+	perp := f32.Pt(-d.Y, d.X).Mul(0.4)
+	base := tip.Sub(d)
+	var path clip.Path
+	path.Begin(gtx.Ops)
+	path.MoveTo(base.Add(perp))
+	path.LineTo(tip)
+	path.LineTo(base.Sub(perp))
+	if open {
+		paint.FillShape(gtx.Ops, p.fg, clip.Stroke{Path: path.End(), Width: p.strokeWidth(gtx)}.Op())
+		return
+	}
+	path.Close()
+	paint.FillShape(gtx.Ops, p.fg, clip.Outline{Path: path.End()}.Op())
+}
+
+// box draws a filled rectangle with an outline, and with rounded corners if radius isn't 0.
+func (p diagramPen) box(gtx C, r image.Rectangle, radius int, fill color.NRGBA) {
+	rr := clip.UniformRRect(r, radius)
+	paint.FillShape(gtx.Ops, fill, rr.Op(gtx.Ops))
+	paint.FillShape(gtx.Ops, p.fg, clip.Stroke{Path: rr.Path(gtx.Ops), Width: p.strokeWidth(gtx)}.Op())
+}
+
+func (p diagramPen) ellipse(gtx C, r image.Rectangle) {
+	e := clip.Ellipse(r)
+	paint.FillShape(gtx.Ops, p.fill, e.Op(gtx.Ops))
+	paint.FillShape(gtx.Ops, p.fg, clip.Stroke{Path: e.Path(gtx.Ops), Width: p.strokeWidth(gtx)}.Op())
+}
+
+func (p diagramPen) diamond(gtx C, r image.Rectangle) {
+	c := layout.FPt(r.Min.Add(r.Max).Div(2))
+	hw, hh := float32(r.Dx())/2, float32(r.Dy())/2
+	path := func() clip.PathSpec {
+		var path clip.Path
+		path.Begin(gtx.Ops)
+		path.MoveTo(c.Add(f32.Pt(0, -hh)))
+		path.LineTo(c.Add(f32.Pt(hw, 0)))
+		path.LineTo(c.Add(f32.Pt(0, hh)))
+		path.LineTo(c.Add(f32.Pt(-hw, 0)))
+		path.Close()
+		return path.End()
+	}
+	paint.FillShape(gtx.Ops, p.fill, clip.Outline{Path: path()}.Op())
+	paint.FillShape(gtx.Ops, p.fg, clip.Stroke{Path: path(), Width: p.strokeWidth(gtx)}.Op())
+}
+
+// diagramLabel turns the escapes in a diagram's label into the line breaks they stand for.
+func diagramLabel(s string) string {
+	return strings.NewReplacer(`\n`, "\n", `\l`, "\n", `\r`, "\n").Replace(s)
+}
+
+func min32(a, b float32) float32 {
+	if a < b {
+		return a
+	}
+	return b
+}
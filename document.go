@@ -6,8 +6,11 @@ import (
 	"image"
 	"image/color"
 	"io"
+	"sort"
 	"strings"
+	"unicode"
 
+	"gioui.org/gesture"
 	"gioui.org/layout"
 	"gioui.org/op"
 	"gioui.org/op/clip"
@@ -18,52 +21,250 @@ import (
 	"gioui.org/x/richtext"
 	"github.com/yuin/goldmark"
 	"github.com/yuin/goldmark/ast"
+	extast "github.com/yuin/goldmark/extension/ast"
 	"github.com/yuin/goldmark/renderer"
 	"github.com/yuin/goldmark/util"
 )
 
 type Document struct {
-	renderer  *docRenderer
-	textState richtext.InteractiveText
-	elements  []spanGroup
-	elemList  widget.List
+	err      error        // the error from the latest render, if it failed
+	states   []blockState // one for each element
+	elements []spanGroup
+	elemList widget.List
+
+	links  []string // the destinations of the links clicked since they were last taken
+	tasks  []int    // the offsets of the task checkboxes clicked since they were last taken
+	jumped bool     // whether a block was double-clicked since it was last taken
+	jumpTo int      // the first line of the source of the block that was double-clicked
+
+	// anchor is the id of a heading to scroll to once it's been rendered. It's given up on
+	// if there isn't a heading with it once a render was set after it.
+	anchor         string
+	anchorRendered bool
+
+	images *imageCache
+	dir    string // the directory that relative image paths are relative to
 }
 
-func (d *Document) Render(data []byte, th *material.Theme) error {
-	if d.renderer == nil {
-		d.renderer = newDocRenderer()
-	}
-	elements, err := d.renderer.Render(th, data)
+// useImages sets where the document's images are loaded from.
+func (d *Document) useImages(c *imageCache, dir string) {
+	d.images = c
+	d.dir = dir
+}
+
+// setRender sets the result of a render. If it failed, the elements from before are kept and
+// the error is shown above them.
+func (d *Document) setRender(elements []spanGroup, err error) {
+	d.err = err
 	if err != nil {
-		return err
+		return
 	}
+	d.anchorRendered = true
 	d.elements = elements
-	return nil
+	if n := len(elements) - len(d.states); n > 0 {
+		d.states = append(d.states, make([]blockState, n)...)
+	}
+}
+
+// Links returns the destinations of the links that were clicked since the last call, other
+// than the ones to headings within the document itself (which it scrolls to on its own).
+func (d *Document) Links() []string {
+	l := d.links
+	d.links = nil
+	return l
+}
+
+// TaskClicks returns the offsets (into the source) of the task checkboxes that were clicked
+// since the last call.
+func (d *Document) TaskClicks() []int {
+	t := d.tasks
+	d.tasks = nil
+	return t
+}
+
+// SourceClicked returns the first line of the source of the block that was last
+// double-clicked, if one was since the last call.
+func (d *Document) SourceClicked() (int, bool) {
+	ok := d.jumped
+	d.jumped = false
+	return d.jumpTo, ok
+}
+
+// ScrollToLine scrolls the document to the element that was rendered from the given line
+// of the source (or the first one after it).
+func (d *Document) ScrollToLine(row int) {
+	for i := range d.elements {
+		if d.elements[i].lastLine >= row {
+			d.elemList.Position.First = i
+			d.elemList.Position.Offset = 0
+			return
+		}
+	}
+}
+
+// TopLine returns the first line of the source of the element at the top of the document's
+// view.
+func (d *Document) TopLine() int {
+	if i := d.elemList.Position.First; i < len(d.elements) {
+		return d.elements[i].firstLine
+	}
+	return 0
+}
+
+// ScrollToHeading scrolls the document to the heading with the given id (as in a link's
+// `#fragment`) once it's next laid out, or once it's been rendered if it hasn't yet (such
+// as when the document was just opened).
+func (d *Document) ScrollToHeading(id string) {
+	d.anchor = id
+	d.anchorRendered = false
+}
+
+// takeClicks goes through the clicks on any links or task checkboxes within the given text.
+func (d *Document) takeClicks(st *richtext.InteractiveText) {
+	for span, events := st.Events(); span != nil; span, events = st.Events() {
+		for _, e := range events {
+			if e.Type == richtext.Click {
+				d.clickSpan(span.Get)
+			}
+		}
+	}
+}
+
+// clickSpan handles a click on an interactive span, whose metadata is looked up with get.
+func (d *Document) clickSpan(get func(key string) interface{}) {
+	if i, ok := get(detailsKey).(int); ok && i < len(d.states) {
+		d.states[i].toggled = !d.states[i].toggled
+		return
+	}
+	if off, ok := get(taskOffsetKey).(int); ok {
+		d.tasks = append(d.tasks, off)
+		return
+	}
+	dest, _ := get(linkDestKey).(string)
+	if strings.HasPrefix(dest, "#") {
+		d.ScrollToHeading(dest[1:])
+	} else if dest != "" {
+		d.links = append(d.links, dest)
+	}
+}
+
+// blockState is the state of the widgets within one of the document's blocks.
+type blockState struct {
+	text    richtext.InteractiveText
+	flow    flowState                  // for text that's laid out by layFlow instead
+	cells   []richtext.InteractiveText // one for each cell of a table
+	flows   []flowState                // one for each cell of a table
+	scroll  widget.List                // for scrolling a table sideways
+	click   gesture.Click              // for jumping to the block's source
+	toggled bool                       // whether a `<details>` summary was clicked to show or hide it
+}
+
+func (d *Document) update() {
+	for i := range d.states {
+		d.takeClicks(&d.states[i].text)
+		for j := range d.states[i].cells {
+			d.takeClicks(&d.states[i].cells[j])
+		}
+	}
+	if d.anchor == "" {
+		return
+	}
+	for i := range d.elements {
+		if anchorID(d.elements[i].mdata) == d.anchor {
+			d.elemList.Position.First = i
+			d.elemList.Position.Offset = 0
+			d.anchor = ""
+			return
+		}
+	}
+	if d.anchorRendered {
+		d.anchor = ""
+	}
 }
 
 func (d *Document) Layout(gtx C, th *material.Theme) D {
 	if d.elemList.Axis != layout.Vertical {
 		d.elemList.Axis = layout.Vertical
 	}
+	d.update()
+	if len(d.links) > 0 || len(d.tasks) > 0 || d.jumped {
+		// They're taken before the next layout.
+		op.InvalidateOp{}.Add(gtx.Ops)
+	}
 	return layout.Inset{Left: 15, Right: 10}.Layout(gtx, func(gtx C) D {
-		return material.List(th, &d.elemList).Layout(gtx, len(d.elements), func(gtx C, i int) D {
-			return d.layBlock(gtx, th, &d.elements[i])
-		})
+		return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+			layout.Rigid(func(gtx C) D {
+				if d.err == nil {
+					return D{}
+				}
+				return layout.Inset{Top: 6, Bottom: 6}.Layout(gtx, func(gtx C) D {
+					l := material.Body2(th, "Couldn't render the preview: "+d.err.Error())
+					l.Color = color.NRGBA{200, 70, 70, 255}
+					return l.Layout(gtx)
+				})
+			}),
+			layout.Flexed(1, func(gtx C) D {
+				return material.List(th, &d.elemList).Layout(gtx, len(d.elements), func(gtx C, i int) D {
+					if d.hidden(i) {
+						return D{}
+					}
+					return d.layBlock(gtx, th, &d.elements[i], &d.states[i])
+				})
+			}),
+		)
 	})
 }
 
-func (d *Document) layBlock(gtx C, th *material.Theme, blk *spanGroup) D {
+// layBlock lays out one of the document's blocks. Double-clicking it (anywhere that isn't a
+// link or checkbox, which take single clicks) jumps to its source.
+func (d *Document) layBlock(gtx C, th *material.Theme, blk *spanGroup, st *blockState) D {
+	for _, e := range st.click.Events(gtx) {
+		if e.Type == gesture.TypeClick && e.NumClicks == 2 {
+			d.jumped, d.jumpTo = true, blk.firstLine
+		}
+	}
+	m := op.Record(gtx.Ops)
+	dims := d.layBlockContent(gtx, th, blk, st)
+	call := m.Stop()
+	defer clip.Rect{Max: dims.Size}.Push(gtx.Ops).Pop()
+	st.click.Add(gtx.Ops)
+	call.Add(gtx.Ops)
+	return dims
+}
+
+func (d *Document) layBlockContent(gtx C, th *material.Theme, blk *spanGroup, st *blockState) D {
 	return layout.Inset{Bottom: 24}.Layout(gtx, func(gtx C) D {
 		switch blk.mdata.(type) {
+		case isImage:
+			return d.layImage(gtx, th, blk.mdata.(isImage), &st.text)
+		case isTable:
+			return d.layTable(gtx, th, blk.mdata.(isTable), st)
+		case isMath:
+			return layMath(gtx, th, blk, st)
+		case isDiagram:
+			return layDiagram(gtx, th, blk.mdata.(isDiagram), st)
+		case isDetails:
+			return d.layDetails(gtx, th, blk, st)
 		case isHr:
 			size := image.Point{gtx.Constraints.Max.X, 1}
 			rect := clip.Rect{Max: size}.Op()
 			paint.FillShape(gtx.Ops, color.NRGBA{120, 120, 120, 255}, rect)
 			return D{Size: size}
+		case isFrontMatter:
+			m := op.Record(gtx.Ops)
+			dims := layout.Inset{Top: 10, Bottom: 10, Left: 15, Right: 15}.Layout(gtx, func(gtx C) D {
+				return richtext.Text(&st.text, th.Shaper, blk.items...).Layout(gtx)
+			})
+			call := m.Stop()
+			dims.Size.X = gtx.Constraints.Max.X
+			rect := clip.UniformRRect(image.Rectangle{Max: dims.Size}, 6).Op(gtx.Ops)
+			paint.FillShape(gtx.Ops, color.NRGBA{120, 140, 200, 25}, rect)
+			call.Add(gtx.Ops)
+			return dims
 		case isFencedCodeBlock:
 			m := op.Record(gtx.Ops)
 			dims := layout.UniformInset(15).Layout(gtx, func(gtx C) D {
-				return richtext.Text(&d.textState, th.Shaper, blk.items...).Layout(gtx)
+				return richtext.Text(&st.text, th.Shaper, blk.items...).Layout(gtx)
 			})
 			call := m.Stop()
 			rect := clip.Rect{Max: dims.Size}.Op()
@@ -73,7 +274,7 @@ func (d *Document) layBlock(gtx C, th *material.Theme, blk *spanGroup) D {
 		case isBlockquote:
 			m := op.Record(gtx.Ops)
 			gtx.Constraints.Max.X -= 28
-			dims := richtext.Text(&d.textState, th.Shaper, blk.items...).Layout(gtx)
+			dims := d.layText(gtx, th, blk.items, blk.flow, &st.text, &st.flow)
 			call := m.Stop()
 			return layout.Flex{}.Layout(gtx,
 				layout.Rigid(func(gtx C) D {
@@ -90,7 +291,7 @@ func (d *Document) layBlock(gtx C, th *material.Theme, blk *spanGroup) D {
 				}),
 			)
 		default:
-			return richtext.Text(&d.textState, th.Shaper, blk.items...).Layout(gtx)
+			return d.layText(gtx, th, blk.items, blk.flow, &st.text, &st.flow)
 		}
 	})
 }
@@ -98,15 +299,58 @@ func (d *Document) layBlock(gtx C, th *material.Theme, blk *spanGroup) D {
 type spanGroup struct {
 	mdata interface{}
 	items []richtext.SpanStyle
+
+	firstLine int // the first line of the source that the group was rendered from
+	lastLine  int // the last line of the source that the group was rendered from
+	section   int // one more than the index of the summary of the `<details>` it's within, or 0
+
+	flow []flowSpan // what layFlow needs to know of each item, if the group is laid out by it
+}
+
+// srcExtent is a range of the source (by byte offsets).
+type srcExtent struct {
+	start, stop int
+	ok          bool // whether the range has anything in it
+}
+
+func (e *srcExtent) add(start, stop int) {
+	if !e.ok || start < e.start {
+		e.start = start
+	}
+	if !e.ok || stop > e.stop {
+		e.stop = stop
+	}
+	e.ok = true
 }
 
 type spanBuilder struct {
 	theme   *material.Theme
+	src     []byte
 	result  []spanGroup
 	current spanGroup
-
-	list listState
-}
+	extents []srcExtent // the source of each group of the result
+	extent  srcExtent   // the source of the current group
+
+	lists       []listState        // the lists being rendered, from the outermost to the innermost
+	codeKind    tokenKind          // the kind of code token that the current span is styled for
+	linkStyle   richtext.SpanStyle // the style of the text around the link being rendered
+	slugs       map[string]int     // how many headings so far have each id
+	table       *isTable           // the table being rendered (if any)
+	tableOuter  spanGroup          // what's left of the group that the table is within, which goes on after it
+	definitions int                // how many definition lists are being rendered
+	struckColor color.NRGBA        // the color of the text around the struck through text
+	struck      bool               // whether struck through text is being rendered
+	struckFrom  int                // the index of the current group's first struck through item
+	htmlStyles  []htmlStyle        // the inline HTML elements that are open in the current group
+	summary     bool               // whether the current group is the summary of a `<details>`
+	sections    []int              // the sections of the `<details>` elements that are open
+}
+
+// These are the keys of an interactive span's metadata.
+const (
+	linkDestKey   = "dest" // the destination of a link
+	taskOffsetKey = "task" // the offset of a task item's checkbox in the source
+)
 
 func (sb *spanBuilder) newSpan(l material.LabelStyle) {
 	sb.current.items = append(sb.current.items, richtext.SpanStyle{})
@@ -128,8 +372,34 @@ func (sb *spanBuilder) useStyle(l material.LabelStyle) {
 }
 
 func (sb *spanBuilder) commitGroup() {
+	// Nothing is left of a block after an image, math or a table that ended it.
+	if sb.current.mdata == nil && len(sb.result) > 0 && !sb.hasContent() {
+		switch sb.result[len(sb.result)-1].mdata.(type) {
+		case isImage, isMath, isTable:
+			sb.finishFlow()
+			sb.current = spanGroup{}
+			return
+		}
+	}
+	sb.finishFlow()
+	if n := len(sb.sections); n > 0 {
+		sb.current.section = sb.sections[n-1]
+	}
 	sb.result = append(sb.result, sb.current)
+	sb.extents = append(sb.extents, sb.extent)
 	sb.current = spanGroup{}
+	sb.extent = srcExtent{}
+	sb.htmlStyles = nil
+}
+
+// hasContent reports whether the current group has any text.
+func (sb *spanBuilder) hasContent() bool {
+	for _, s := range sb.current.items {
+		if s.Content != "" {
+			return true
+		}
+	}
+	return false
 }
 
 func (sb *spanBuilder) writeLines(source []byte, n ast.Node) {
@@ -145,7 +415,111 @@ func (sb *spanBuilder) writeLines(source []byte, n ast.Node) {
 	}
 }
 
+// writeCodeLines is like writeLines, except that the lines are broken up into spans that are
+// colored according to the given language's tokens.
+func (sb *spanBuilder) writeCodeLines(source []byte, n ast.Node, lang *codeLang) {
+	var st tokState
+	sb.codeKind = tokenPlain
+	l := n.Lines().Len()
+	for i := 0; i < l; i++ {
+		line := n.Lines().At(i)
+		v := line.Value(source)
+		v = bytes.ReplaceAll(v, []byte{'\t'}, []byte("    ")) // TODO this should only replace leading tab characters not in strings
+		v = bytes.TrimSuffix(v, []byte{'\n'})
+		col := 0
+		for _, tok := range lang.tokenize(v, &st) {
+			sb.writeCode(v[col:tok.start], tokenPlain)
+			sb.writeCode(v[tok.start:tok.end], tok.kind)
+			col = tok.end
+		}
+		sb.writeCode(v[col:], tokenPlain)
+		if i != l-1 {
+			sb.writeCode([]byte{'\n'}, tokenPlain)
+		}
+	}
+}
+
+// codeTokenColors are the colors of each kind of token (other than plain ones) in a fenced
+// code block.
+var codeTokenColors = [...]color.NRGBA{
+	tokenKeyword: {200, 120, 190, 255},
+	tokenString:  {150, 190, 110, 255},
+	tokenComment: {130, 130, 130, 255},
+	tokenNumber:  {220, 160, 90, 255},
+}
+
+// writeCode adds the given code to the current span, unless that span is styled for a
+// different kind of token, in which case the code goes into a new span.
+func (sb *spanBuilder) writeCode(code []byte, kind tokenKind) {
+	if len(code) == 0 {
+		return
+	}
+	if kind != sb.codeKind {
+		l := material.Body1(sb.theme, "")
+		l.Font.Variant = "Mono"
+		switch kind {
+		case tokenPlain:
+		case tokenKeyword:
+			l.Font.Weight = text.Bold
+			fallthrough
+		default:
+			l.Color = codeTokenColors[kind]
+		}
+		if kind == tokenComment {
+			l.Font.Style = text.Italic
+		}
+		sb.newSpan(l)
+		sb.codeKind = kind
+	}
+	sb.currentSpan().Content += string(code)
+}
+
+// trackingRegisterer registers node renderers that first count the source of each node they
+// render toward the group being built.
+type trackingRegisterer struct {
+	renderer.NodeRendererFuncRegisterer
+	sb *spanBuilder
+}
+
+func (r trackingRegisterer) Register(kind ast.NodeKind, f renderer.NodeRendererFunc) {
+	r.NodeRendererFuncRegisterer.Register(kind, func(w util.BufWriter, src []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering {
+			r.sb.extend(n)
+		}
+		return f(w, src, n, entering)
+	})
+}
+
+// extend adds the source of the given node to that of the current group.
+func (sb *spanBuilder) extend(n ast.Node) {
+	switch n := n.(type) {
+	case *ast.Text:
+		sb.extent.add(n.Segment.Start, n.Segment.Stop)
+	case *ast.RawHTML:
+		for i := 0; i < n.Segments.Len(); i++ {
+			sb.extent.add(n.Segments.At(i).Start, n.Segments.At(i).Stop)
+		}
+	case *ast.FencedCodeBlock:
+		if n.Info != nil {
+			sb.extent.add(n.Info.Segment.Start, n.Info.Segment.Stop)
+		}
+	case *mathInline:
+		sb.extent.add(n.Segment.Start, n.Segment.Stop)
+	case *mathBlock:
+		sb.extent.add(n.open, n.open+2)
+		if n.close != -1 {
+			sb.extent.add(n.close, n.close+2)
+		}
+	}
+	if n.Type() == ast.TypeBlock {
+		for i := 0; i < n.Lines().Len(); i++ {
+			sb.extent.add(n.Lines().At(i).Start, n.Lines().At(i).Stop)
+		}
+	}
+}
+
 func (sb *spanBuilder) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg = trackingRegisterer{reg, sb}
 	// blocks
 	reg.Register(ast.KindDocument, sb.renderDocument)
 	reg.Register(ast.KindHeading, sb.renderHeading)
@@ -158,12 +532,27 @@ func (sb *spanBuilder) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
 	reg.Register(ast.KindParagraph, sb.renderParagraph)
 	reg.Register(ast.KindTextBlock, sb.renderTextBlock)
 	reg.Register(ast.KindThematicBreak, sb.renderThematicBreak)
+	reg.Register(extast.KindTable, sb.renderTable)
+	reg.Register(extast.KindTableHeader, sb.renderTableRow)
+	reg.Register(extast.KindTableRow, sb.renderTableRow)
+	reg.Register(extast.KindTableCell, sb.renderTableCell)
+	reg.Register(extast.KindTaskCheckBox, sb.renderTaskCheckBox)
+	reg.Register(extast.KindStrikethrough, sb.renderStrikethrough)
+	reg.Register(extast.KindFootnoteLink, sb.renderFootnoteLink)
+	reg.Register(extast.KindFootnoteBacklink, sb.renderFootnoteBacklink)
+	reg.Register(extast.KindFootnoteList, sb.renderFootnoteList)
+	reg.Register(extast.KindFootnote, sb.renderFootnote)
+	reg.Register(extast.KindDefinitionList, sb.renderDefinitionList)
+	reg.Register(extast.KindDefinitionTerm, sb.renderDefinitionTerm)
+	reg.Register(extast.KindDefinitionDescription, sb.renderDefinitionDescription)
+	reg.Register(kindMathBlock, sb.renderMathBlock)
 	// inlines
 	reg.Register(ast.KindAutoLink, sb.renderAutoLink)
 	reg.Register(ast.KindCodeSpan, sb.renderCodeSpan)
 	reg.Register(ast.KindEmphasis, sb.renderEmphasis)
 	reg.Register(ast.KindImage, sb.renderImage)
 	reg.Register(ast.KindLink, sb.renderLink)
+	reg.Register(kindMathInline, sb.renderMathInline)
 	reg.Register(ast.KindRawHTML, sb.renderRawHTML)
 	reg.Register(ast.KindText, sb.renderText)
 	reg.Register(ast.KindString, sb.renderString)
@@ -191,6 +580,7 @@ func (sb *spanBuilder) renderHeading(_ util.BufWriter, src []byte, node ast.Node
 		}
 		l := h(sb.theme, "")
 		sb.useStyle(l)
+		sb.current.mdata = isHeading{id: sb.headingID(n.Text(src))}
 	} else {
 		sb.commitGroup()
 	}
@@ -214,24 +604,51 @@ func (sb *spanBuilder) renderFencedCodeBlock(_ util.BufWriter, src []byte, n ast
 	if entering {
 		sb.current.mdata = isFencedCodeBlock{}
 		sb.currentSpan().Font.Variant = "Mono"
-		sb.writeLines(src, n) // TODO store the text content in the spanGroup metadata
+		// TODO store the text content in the spanGroup metadata
+		if lang := codeLangFor(n.(*ast.FencedCodeBlock).Language(src)); lang != nil {
+			sb.writeCodeLines(src, n, lang)
+		} else {
+			sb.writeLines(src, n)
+		}
+		// A diagram is drawn instead, unless it can't be parsed. Its source is shown then
+		// (along with why) like any other code.
+		switch d, err := parseDiagram(src, n.(*ast.FencedCodeBlock)); {
+		case d != nil:
+			sb.current.mdata = isDiagram{d}
+		case err != nil:
+			l := material.Body2(sb.theme, "")
+			l.Color.A = 120
+			sb.newSpan(l)
+			sb.currentSpan().Content = "\n" + err.Error()
+		}
 	} else {
 		sb.commitGroup()
 	}
 	return ast.WalkContinue, nil
 }
 
-func (sb *spanBuilder) renderHTMLBlock(_ util.BufWriter, _ []byte, _ ast.Node, _ bool) (ast.WalkStatus, error) {
-	return ast.WalkContinue, nil
-}
+// listBullets are the bullets of unordered list items at each level of nesting.
+var listBullets = [...]string{"•", "–", "›"}
 
+// renderList renders a list (along with any lists nested within it) as a single group.
 func (sb *spanBuilder) renderList(_ util.BufWriter, _ []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
 	if entering {
 		n := node.(*ast.List)
-		sb.list.isOrdered = n.IsOrdered()
-		sb.list.index = 0
-	} else {
-		sb.currentSpan().Content = strings.TrimSuffix(sb.currentSpan().Content, "\n")
+		// A nested list starts on the line after its item's text.
+		if len(sb.lists) > 0 {
+			sb.endLine()
+		}
+		sb.lists = append(sb.lists, listState{isOrdered: n.IsOrdered(), index: n.Start})
+		return ast.WalkContinue, nil
+	}
+	sb.lists = sb.lists[:len(sb.lists)-1]
+	if len(sb.lists) == 0 {
+		for i := len(sb.current.items) - 1; i >= 0; i-- {
+			if c := &sb.current.items[i].Content; *c != "" {
+				*c = strings.TrimSuffix(*c, "\n")
+				break
+			}
+		}
 		sb.commitGroup()
 	}
 	return ast.WalkContinue, nil
@@ -240,21 +657,42 @@ func (sb *spanBuilder) renderList(_ util.BufWriter, _ []byte, node ast.Node, ent
 func (sb *spanBuilder) renderListItem(_ util.BufWriter, _ []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
 	if entering {
 		sb.newSpan(material.Body1(sb.theme, ""))
-		prefix := "  •  "
-		if sb.list.isOrdered {
-			sb.list.index++
-			prefix = fmt.Sprintf("  %d.  ", sb.list.index)
+		depth := len(sb.lists) - 1
+		list := &sb.lists[depth]
+		indent := strings.Repeat("    ", depth)
+		prefix := indent + "  " + listBullets[depth%len(listBullets)] + "  "
+		if list.isOrdered {
+			prefix = fmt.Sprintf("%s  %d.  ", indent, list.index)
+			list.index++
 		}
 		sb.currentSpan().Content = prefix
 	} else {
-		sb.currentSpan().Content += "\n"
+		sb.endLine()
 	}
 	return ast.WalkContinue, nil
 }
 
+// endLine starts a new line unless the text of the current group already ends with one.
+func (sb *spanBuilder) endLine() {
+	for i := len(sb.current.items) - 1; i >= 0; i-- {
+		if c := sb.current.items[i].Content; c != "" {
+			if !strings.HasSuffix(c, "\n") {
+				sb.currentSpan().Content += "\n"
+			}
+			return
+		}
+	}
+}
+
 func (sb *spanBuilder) renderParagraph(_ util.BufWriter, _ []byte, _ ast.Node, entering bool) (ast.WalkStatus, error) {
 	if !entering {
-		sb.commitGroup()
+		// The paragraphs of a loose list's items (or of definitions) stay within the
+		// list's group.
+		if len(sb.lists) > 0 || sb.definitions > 0 {
+			sb.endLine()
+		} else {
+			sb.commitGroup()
+		}
 	}
 	return ast.WalkContinue, nil
 }
@@ -272,7 +710,13 @@ func (sb *spanBuilder) renderThematicBreak(_ util.BufWriter, _ []byte, _ ast.Nod
 	return ast.WalkContinue, nil
 }
 
-func (sb *spanBuilder) renderAutoLink(_ util.BufWriter, _ []byte, _ ast.Node, _ bool) (ast.WalkStatus, error) {
+func (sb *spanBuilder) renderAutoLink(_ util.BufWriter, src []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		n := node.(*ast.AutoLink)
+		sb.beginLink(string(n.URL(src)))
+		sb.currentSpan().Content += string(n.Label(src))
+		sb.endLink()
+	}
 	return ast.WalkContinue, nil
 }
 
@@ -305,18 +749,123 @@ func (sb *spanBuilder) renderEmphasis(_ util.BufWriter, _ []byte, node ast.Node,
 	return ast.WalkContinue, nil
 }
 
-func (sb *spanBuilder) renderImage(_ util.BufWriter, _ []byte, _ ast.Node, _ bool) (ast.WalkStatus, error) {
+// renderTaskCheckBox renders a task item's checkbox as an interactive span, which toggles
+// the checkbox on the item's line in the source when it's clicked.
+func (sb *spanBuilder) renderTaskCheckBox(_ util.BufWriter, _ []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*extast.TaskCheckBox)
+	style := *sb.currentSpan()
+	box := richtext.SpanStyle{
+		Font:        text.Font{Variant: "Mono", Weight: text.Bold},
+		Size:        style.Size,
+		Color:       color.NRGBA{230, 170, 60, 255},
+		Content:     "[ ]",
+		Interactive: true,
+	}
+	if n.IsChecked {
+		box.Color = color.NRGBA{120, 170, 110, 255}
+		box.Content = "[x]"
+	}
+	sb.current.items = append(sb.current.items, box)
+	// The checkbox is the first thing on its line, which starts the block that it's in. Its
+	// parent might be an inline though, like in `- **[x] done**`.
+	p := n.Parent()
+	for p != nil && p.Type() != ast.TypeBlock {
+		p = p.Parent()
+	}
+	if p != nil && p.Lines().Len() > 0 {
+		sb.setMeta(len(sb.current.items)-1, taskOffsetKey, p.Lines().At(0).Start)
+	}
+	style.Content = " "
+	sb.current.items = append(sb.current.items, style)
 	return ast.WalkContinue, nil
 }
 
-func (sb *spanBuilder) renderLink(_ util.BufWriter, _ []byte, _ ast.Node, _ bool) (ast.WalkStatus, error) {
+// renderStrikethrough dims the struck through text, which is laid out by layFlow so that it
+// has a line through it.
+func (sb *spanBuilder) renderStrikethrough(_ util.BufWriter, _ []byte, _ ast.Node, entering bool) (ast.WalkStatus, error) {
+	style := *sb.currentSpan()
+	style.Content = ""
+	if entering {
+		sb.struckColor = style.Color
+		style.Color.A /= 2
+		sb.struck, sb.struckFrom = true, len(sb.current.items)
+	} else {
+		style.Color = sb.struckColor
+		sb.strike()
+		sb.struck = false
+	}
+	sb.current.items = append(sb.current.items, style)
 	return ast.WalkContinue, nil
 }
 
-func (sb *spanBuilder) renderRawHTML(_ util.BufWriter, _ []byte, _ ast.Node, _ bool) (ast.WalkStatus, error) {
+func (sb *spanBuilder) renderImage(_ util.BufWriter, src []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	if sb.table != nil {
+		// Only the alt text can be shown within a table's cell.
+		return ast.WalkContinue, nil
+	}
+	n := node.(*ast.Image)
+	sb.addImage(isImage{
+		dest: string(n.Destination),
+		alt:  string(n.Text(src)),
+	})
+	return ast.WalkSkipChildren, nil
+}
+
+// addImage puts an image into a group of its own, splitting up the block that it's in.
+func (sb *spanBuilder) addImage(img isImage) {
+	style := *sb.currentSpan()
+	outer := sb.current.mdata
+	if sb.hasContent() {
+		sb.commitGroup()
+	}
+	sb.current = spanGroup{mdata: img}
+	sb.commitGroup()
+	// The rest of the block goes on in the same style (and as the same kind of block).
+	sb.current = spanGroup{mdata: outer}
+	sb.current.items = append(sb.current.items, richtext.SpanStyle{
+		Font:  style.Font,
+		Size:  style.Size,
+		Color: style.Color,
+	})
+}
+
+func (sb *spanBuilder) renderLink(_ util.BufWriter, _ []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		sb.beginLink(string(node.(*ast.Link).Destination))
+	} else {
+		sb.endLink()
+	}
 	return ast.WalkContinue, nil
 }
 
+// beginLink starts an interactive span for a link to the given destination, which is in the
+// same font as the text around it.
+func (sb *spanBuilder) beginLink(dest string) {
+	sb.linkStyle = *sb.currentSpan()
+	sb.current.items = append(sb.current.items, richtext.SpanStyle{
+		Font:        sb.linkStyle.Font,
+		Size:        sb.linkStyle.Size,
+		Color:       sb.theme.ContrastFg,
+		Interactive: true,
+	})
+	sb.setMeta(len(sb.current.items)-1, linkDestKey, dest)
+}
+
+// endLink goes back to the style of the text from before the link.
+func (sb *spanBuilder) endLink() {
+	sb.current.items = append(sb.current.items, richtext.SpanStyle{
+		Font:  sb.linkStyle.Font,
+		Size:  sb.linkStyle.Size,
+		Color: sb.linkStyle.Color,
+	})
+}
+
 func (sb *spanBuilder) renderText(_ util.BufWriter, src []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
 	if entering {
 		n := node.(*ast.Text)
@@ -331,18 +880,79 @@ func (sb *spanBuilder) renderText(_ util.BufWriter, src []byte, node ast.Node, e
 	return ast.WalkContinue, nil
 }
 
-func (sb *spanBuilder) renderString(_ util.BufWriter, _ []byte, node ast.Node, _ bool) (ast.WalkStatus, error) {
-	n := node.(*ast.String)
-	sb.currentSpan().Content += string(n.Value)
+func (sb *spanBuilder) renderString(_ util.BufWriter, _ []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		n := node.(*ast.String)
+		sb.currentSpan().Content += string(n.Value)
+	}
 	return ast.WalkContinue, nil
 }
 
+// headingID returns the id of a heading with the given text, which is what a `#fragment`
+// link to it goes by. Like on GitHub, headings with the same text get a number appended to
+// the ids of all but the first of them.
+func (sb *spanBuilder) headingID(txt []byte) string {
+	return uniqueSlug(sb.slugs, txt)
+}
+
+// uniqueSlug returns the slug of a heading with the given text, numbered by how many
+// headings before it (as counted in slugs) have the same one.
+func uniqueSlug(slugs map[string]int, txt []byte) string {
+	id := headingSlug(string(txt))
+	n := slugs[id]
+	slugs[id]++
+	if n > 0 {
+		return fmt.Sprintf("%s-%d", id, n)
+	}
+	return id
+}
+
+// headingSlug turns the text of a heading into an id the way GitHub does: in lower case, with
+// hyphens for spaces, and without any punctuation other than hyphens and underscores.
+func headingSlug(txt string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(txt) {
+		switch {
+		case unicode.IsLetter(r), unicode.IsDigit(r), unicode.IsMark(r), r == '-', r == '_':
+			b.WriteRune(r)
+		case r == ' ':
+			b.WriteByte('-')
+		}
+	}
+	return b.String()
+}
+
 func (sb *spanBuilder) Result() []spanGroup {
 	res := sb.result
-	sb.result = nil
+	sb.setLines(res)
+	sb.result, sb.extents = nil, nil
 	return res
 }
 
+// setLines sets the lines of the source that each group was rendered from. A group without
+// any source of its own (like a thematic break) goes with the end of the one before it.
+func (sb *spanBuilder) setLines(groups []spanGroup) {
+	var starts []int
+	for i, c := range sb.src {
+		if c == '\n' {
+			starts = append(starts, i+1)
+		}
+	}
+	lineOf := func(off int) int {
+		return sort.SearchInts(starts, off+1)
+	}
+	last := 0
+	for i := range groups {
+		if e := sb.extents[i]; e.ok {
+			groups[i].firstLine = lineOf(e.start)
+			groups[i].lastLine = max(groups[i].firstLine, lineOf(e.stop-1))
+			last = groups[i].lastLine
+		} else {
+			groups[i].firstLine, groups[i].lastLine = last, last
+		}
+	}
+}
+
 type docRenderer struct {
 	sb *spanBuilder
 	md goldmark.Markdown
@@ -351,6 +961,7 @@ type docRenderer struct {
 func newDocRenderer() *docRenderer {
 	sb := &spanBuilder{}
 	md := goldmark.New(
+		goldmark.WithParser(mdParser),
 		goldmark.WithRenderer(
 			renderer.NewRenderer(
 				renderer.WithNodeRenderers(util.Prioritized(sb, 0)),
@@ -360,13 +971,23 @@ func newDocRenderer() *docRenderer {
 	return &docRenderer{sb, md}
 }
 
-func (r *docRenderer) Render(th *material.Theme, src []byte) ([]spanGroup, error) {
+func (r *docRenderer) Render(th *material.Theme, doc *mdParse) ([]spanGroup, error) {
 	if r.sb.theme != th {
 		r.sb.theme = th
 	}
+	r.sb.slugs = make(map[string]int)
+	r.sb.src = doc.src
+	r.sb.extent = srcExtent{}
+	r.sb.summary, r.sb.sections = false, nil
+	r.sb.struck = false
+	// Front matter is shown as a card rather than being rendered as markdown.
+	if len(doc.frontMatter) > 0 {
+		r.sb.extent.add(0, len(doc.frontMatter))
+		r.sb.addFrontMatterCard(parseFrontMatter(doc.frontMatter, doc.frontMatterDelim))
+	}
 	l := material.Body1(th, "")
 	r.sb.useStyle(l)
-	if err := r.md.Convert(src, io.Discard); err != nil {
+	if err := r.md.Renderer().Render(io.Discard, doc.src, doc.root); err != nil {
 		return nil, err
 	}
 	return r.sb.Result(), nil
@@ -374,11 +995,29 @@ func (r *docRenderer) Render(th *material.Theme, src []byte) ([]spanGroup, error
 
 type listState struct {
 	isOrdered bool
-	index     int
+	index     int // the number of the next item of an ordered list
 }
 
 type isHr struct{}
 
 type isFencedCodeBlock struct{}
 
+type isFrontMatter struct{}
+
+type isHeading struct {
+	id string
+}
+
+// anchorID returns the id that a link can scroll to a block of the given kind by, or an empty
+// string if it doesn't have one.
+func anchorID(mdata interface{}) string {
+	switch m := mdata.(type) {
+	case isHeading:
+		return m.id
+	case isFootnote:
+		return m.id
+	}
+	return ""
+}
+
 type isBlockquote struct{}
@@ -0,0 +1,102 @@
+package mdedit
+
+import (
+	"reflect"
+	"testing"
+
+	"gioui.org/widget/material"
+)
+
+func TestRenderTaskCheckBoxInInline(t *testing.T) {
+	th := material.NewTheme(nil)
+	for _, src := range []string{
+		"- **[x] done**",
+		"- *[ ] a*",
+		"- ~~[ ] a~~",
+	} {
+		if _, err := newDocRenderer().Render(th, parseMarkdown([]byte(src))); err != nil {
+			t.Errorf("rendering %q: %v", src, err)
+		}
+	}
+}
+
+func TestRenderTableInListItem(t *testing.T) {
+	src := "- item\n\n  | a | b |\n  |---|---|\n  | 1 | 2 |\n- next"
+	groups, err := newDocRenderer().Render(material.NewTheme(nil), parseMarkdown([]byte(src)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var texts []string
+	for _, g := range groups {
+		if _, ok := g.mdata.(isTable); ok {
+			texts = append(texts, "<table>")
+			continue
+		}
+		var txt string
+		for _, s := range g.items {
+			txt += s.Content
+		}
+		texts = append(texts, txt)
+	}
+	want := []string{"  •  item", "<table>", "  •  next"}
+	if !reflect.DeepEqual(texts, want) {
+		t.Errorf("got %q, want %q", texts, want)
+	}
+}
+
+func TestRenderStrikethrough(t *testing.T) {
+	src := "a ~~b [c](x)~~ d"
+	groups, err := newDocRenderer().Render(material.NewTheme(nil), parseMarkdown([]byte(src)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := groups[0]
+	if len(g.flow) != len(g.items) {
+		t.Fatalf("got %d flow spans for %d items", len(g.flow), len(g.items))
+	}
+	for i, s := range g.items {
+		f := g.flow[i]
+		if want := s.Content == "b " || s.Content == "c"; s.Content != "" && f.struck != want {
+			t.Errorf("%q: struck is %v", s.Content, f.struck)
+		}
+		if s.Interactive && f.meta[linkDestKey] != "x" {
+			t.Errorf("%q: the link's destination is %v", s.Content, f.meta[linkDestKey])
+		}
+	}
+}
+
+func TestRenderMathInline(t *testing.T) {
+	for src, laidOut := range map[string]bool{
+		`a $\frac{1}{2}$ b`: true,
+		`a $\frac{1}$ b`:    false,
+	} {
+		groups, err := newDocRenderer().Render(material.NewTheme(nil), parseMarkdown([]byte(src)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		g := groups[0]
+		if got := g.flow != nil && g.flow[1].math != nil; got != laidOut {
+			t.Errorf("%q: got the math laid out as a formula %v, want %v", src, got, laidOut)
+		}
+	}
+}
+
+func TestRenderImageInBlock(t *testing.T) {
+	for src, want := range map[string]interface{}{
+		"# ![logo](x) Project":               isHeading{},
+		"# Project ![logo](x) Name":          isHeading{},
+		"> ![logo](x) quoted":                isBlockquote{},
+		`# <img src="x" alt="logo"> Project`: isHeading{},
+	} {
+		groups, err := newDocRenderer().Render(material.NewTheme(nil), parseMarkdown([]byte(src)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The image splits up the block, which is the same kind of block on either side of it.
+		for _, g := range groups {
+			if _, ok := g.mdata.(isImage); !ok && reflect.TypeOf(g.mdata) != reflect.TypeOf(want) {
+				t.Errorf("%q: got a group of %T, want %T", src, g.mdata, want)
+			}
+		}
+	}
+}
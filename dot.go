@@ -0,0 +1,777 @@
+package mdedit
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"gioui.org/f32"
+	"gioui.org/layout"
+	"gioui.org/unit"
+	"gioui.org/widget/material"
+)
+
+// dotGraph is a graph in (a subset of) Graphviz's DOT language: nodes, edges, their labels,
+// shapes and styles, subgraphs (which are only a way of grouping nodes) and rankdir.
+type dotGraph struct {
+	directed bool
+	rankdir  string
+	nodes    []*dotNode
+	edges    []dotEdge
+	byID     map[string]*dotNode
+	ranks    [][]*dotNode // the nodes of each rank, in order
+
+	// The graph is arranged with the metric and text size that it was first laid out with.
+	arranged bool
+	metric   unit.Metric
+	textSize unit.Sp
+	size     image.Point
+	loop     int // how far loops go out from the side of their nodes
+}
+
+type dotNode struct {
+	id    string
+	attrs map[string]string
+
+	rank, order int
+	center      image.Point
+	size        image.Point
+}
+
+type dotEdge struct {
+	from, to  *dotNode
+	attrs     map[string]string
+	labelSize image.Point
+}
+
+func (n *dotNode) label() string {
+	if l, ok := n.attrs["label"]; ok {
+		return diagramLabel(l)
+	}
+	return n.id
+}
+
+func (n *dotNode) shape() string {
+	return strings.ToLower(n.attrs["shape"])
+}
+
+// border returns where a line from the node's center toward the given point leaves its shape.
+func (n *dotNode) border(toward f32.Point) f32.Point {
+	c := layout.FPt(n.center)
+	d := toward.Sub(c)
+	if d == (f32.Point{}) {
+		return c
+	}
+	hw, hh := float64(n.size.X)/2, float64(n.size.Y)/2
+	dx, dy := math.Abs(float64(d.X)), math.Abs(float64(d.Y))
+	var t float64
+	switch n.shape() {
+	case "", "ellipse", "oval", "circle", "doublecircle", "point":
+		t = 1 / math.Hypot(dx/hw, dy/hh)
+	case "diamond":
+		t = 1 / (dx/hw + dy/hh)
+	default:
+		t = math.Min(hw/dx, hh/dy)
+	}
+	return c.Add(d.Mul(float32(t)))
+}
+
+// parseDOT parses a graph in the DOT language.
+func parseDOT(src string) (diagram, error) {
+	p := &dotParser{lex: dotLexer{src: src}}
+	g, err := p.graph()
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %w", p.lex.line(), err)
+	}
+	g.ranks = g.rank()
+	g.order(g.ranks)
+	return g, nil
+}
+
+type dotParser struct {
+	lex   dotLexer
+	g     *dotGraph
+	peeks []dotToken
+}
+
+type dotToken struct {
+	text   string
+	quoted bool // whether it's a quoted string (and so never a keyword or punctuation)
+}
+
+func (t dotToken) is(s string) bool {
+	return !t.quoted && strings.EqualFold(t.text, s)
+}
+
+func (p *dotParser) next() (dotToken, error) {
+	if len(p.peeks) > 0 {
+		t := p.peeks[0]
+		p.peeks = p.peeks[1:]
+		return t, nil
+	}
+	return p.lex.next()
+}
+
+func (p *dotParser) peek() (dotToken, error) {
+	if len(p.peeks) == 0 {
+		t, err := p.lex.next()
+		if err != nil {
+			return t, err
+		}
+		p.peeks = append(p.peeks, t)
+	}
+	return p.peeks[0], nil
+}
+
+func (p *dotParser) expect(s string) error {
+	t, err := p.next()
+	if err != nil {
+		return err
+	}
+	if !t.is(s) {
+		return fmt.Errorf("expected '%s' but found '%s'", s, t.text)
+	}
+	return nil
+}
+
+func (p *dotParser) graph() (*dotGraph, error) {
+	t, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if t.is("strict") {
+		if t, err = p.next(); err != nil {
+			return nil, err
+		}
+	}
+	p.g = &dotGraph{byID: make(map[string]*dotNode)}
+	switch {
+	case t.is("digraph"):
+		p.g.directed = true
+	case t.is("graph"):
+	default:
+		return nil, errors.New("expected 'graph' or 'digraph'")
+	}
+	if t, err = p.peek(); err != nil {
+		return nil, err
+	}
+	if !t.is("{") {
+		// The graph's name isn't shown.
+		p.next()
+	}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	if _, err := p.stmts(map[string]string{}, map[string]string{}); err != nil {
+		return nil, err
+	}
+	if t, err = p.next(); err != nil {
+		return nil, err
+	}
+	if t.text != "" || t.quoted {
+		return nil, fmt.Errorf("unexpected '%s' after the graph", t.text)
+	}
+	return p.g, nil
+}
+
+// stmts parses statements up to (and including) the closing brace of a graph or subgraph,
+// with the given default attributes of the nodes and edges within it. It returns the nodes
+// that the statements mention.
+func (p *dotParser) stmts(nodeAttrs, edgeAttrs map[string]string) ([]*dotNode, error) {
+	nodeAttrs, edgeAttrs = copyAttrs(nodeAttrs, nil), copyAttrs(edgeAttrs, nil)
+	var nodes []*dotNode
+	for {
+		t, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case t.text == "" && !t.quoted:
+			return nil, errors.New("missing '}'")
+		case t.is("}"):
+			p.next()
+			return nodes, nil
+		case t.is(";"), t.is(","):
+			p.next()
+		case t.is("graph"), t.is("node"), t.is("edge"):
+			p.next()
+			attrs, err := p.attrs()
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case t.is("graph"):
+				p.graphAttrs(attrs)
+			case t.is("node"):
+				nodeAttrs = copyAttrs(nodeAttrs, attrs)
+			default:
+				edgeAttrs = copyAttrs(edgeAttrs, attrs)
+			}
+		default:
+			subgraph := t.is("subgraph") || t.is("{")
+			numNodes := len(p.g.nodes)
+			first, err := p.endpoint(nodeAttrs, edgeAttrs)
+			if err != nil {
+				return nil, err
+			}
+			nodes = append(nodes, first...)
+			if t, err = p.peek(); err != nil {
+				return nil, err
+			}
+			switch {
+			case t.is("="):
+				// An attribute of the graph, like rankdir=LR. Its name is only taken back as
+				// a node if this statement is what made it one (so no edges refer to it).
+				p.next()
+				val, err := p.next()
+				if err != nil {
+					return nil, err
+				}
+				if subgraph || len(first) != 1 {
+					return nil, errors.New("expected an attribute name before '='")
+				}
+				if len(p.g.nodes) != numNodes+1 {
+					return nil, fmt.Errorf("'%s' is a node, not an attribute name", first[0].id)
+				}
+				p.graphAttrs(map[string]string{first[0].id: val.text})
+				p.forget(first[0])
+				nodes = nodes[:len(nodes)-1]
+			case t.is("->"), t.is("--"):
+				more, err := p.edges(first, nodeAttrs, edgeAttrs)
+				if err != nil {
+					return nil, err
+				}
+				nodes = append(nodes, more...)
+			default:
+				if t.is("[") {
+					attrs, err := p.attrs()
+					if err != nil {
+						return nil, err
+					}
+					for _, n := range first {
+						n.attrs = copyAttrs(n.attrs, attrs)
+					}
+				}
+			}
+		}
+	}
+}
+
+// endpoint parses a node's ID or a subgraph, and returns the nodes that it stands for.
+func (p *dotParser) endpoint(nodeAttrs, edgeAttrs map[string]string) ([]*dotNode, error) {
+	t, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case t.is("subgraph"):
+		if t, err = p.next(); err != nil {
+			return nil, err
+		}
+		if !t.is("{") {
+			if err := p.expect("{"); err != nil {
+				return nil, err
+			}
+		}
+		return p.stmts(nodeAttrs, edgeAttrs)
+	case t.is("{"):
+		return p.stmts(nodeAttrs, edgeAttrs)
+	case !t.quoted && strings.ContainsAny(t.text, "[]=;,}") || t.is("->") || t.is("--"):
+		return nil, fmt.Errorf("unexpected '%s'", t.text)
+	}
+	if next, err := p.peek(); err == nil && next.is(":") {
+		return nil, errors.New("ports aren't supported")
+	}
+	n, ok := p.g.byID[t.text]
+	if !ok {
+		n = &dotNode{id: t.text, attrs: copyAttrs(nodeAttrs, nil)}
+		p.g.byID[t.text] = n
+		p.g.nodes = append(p.g.nodes, n)
+	}
+	return []*dotNode{n}, nil
+}
+
+// forget takes back a node that turned out to be the name of a graph attribute.
+func (p *dotParser) forget(n *dotNode) {
+	delete(p.g.byID, n.id)
+	for i, m := range p.g.nodes {
+		if m == n {
+			p.g.nodes = append(p.g.nodes[:i], p.g.nodes[i+1:]...)
+			return
+		}
+	}
+}
+
+// edges parses a chain of edges that starts from the given nodes, and returns the other nodes
+// in it.
+func (p *dotParser) edges(from []*dotNode, nodeAttrs, edgeAttrs map[string]string) ([]*dotNode, error) {
+	var nodes []*dotNode
+	var pairs [][2]*dotNode
+	for {
+		t, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		if !t.is("->") && !t.is("--") {
+			break
+		}
+		switch {
+		case t.is("->") && !p.g.directed:
+			return nil, errors.New("'->' in an undirected graph")
+		case t.is("--") && p.g.directed:
+			return nil, errors.New("'--' in a directed graph")
+		}
+		p.next()
+		to, err := p.endpoint(nodeAttrs, edgeAttrs)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range from {
+			for _, b := range to {
+				pairs = append(pairs, [2]*dotNode{a, b})
+			}
+		}
+		nodes = append(nodes, to...)
+		from = to
+	}
+	attrs := map[string]string{}
+	if t, err := p.peek(); err == nil && t.is("[") {
+		if attrs, err = p.attrs(); err != nil {
+			return nil, err
+		}
+	}
+	for _, pair := range pairs {
+		p.g.edges = append(p.g.edges, dotEdge{from: pair[0], to: pair[1], attrs: copyAttrs(edgeAttrs, attrs)})
+	}
+	return nodes, nil
+}
+
+// attrs parses a list of attributes in brackets (or a few of them one after the other).
+func (p *dotParser) attrs() (map[string]string, error) {
+	attrs := map[string]string{}
+	for {
+		t, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		if !t.is("[") {
+			return attrs, nil
+		}
+		p.next()
+		for {
+			key, err := p.next()
+			if err != nil {
+				return nil, err
+			}
+			if key.is("]") {
+				break
+			}
+			if key.is(",") || key.is(";") {
+				continue
+			}
+			if err := p.expect("="); err != nil {
+				return nil, err
+			}
+			val, err := p.next()
+			if err != nil {
+				return nil, err
+			}
+			attrs[strings.ToLower(key.text)] = val.text
+		}
+	}
+}
+
+func (p *dotParser) graphAttrs(attrs map[string]string) {
+	for k, v := range attrs {
+		if strings.EqualFold(k, "rankdir") {
+			p.g.rankdir = strings.ToUpper(v)
+		}
+	}
+}
+
+// copyAttrs returns a copy of the given attributes with the others added over them.
+func copyAttrs(attrs, others map[string]string) map[string]string {
+	c := make(map[string]string, len(attrs)+len(others))
+	for k, v := range attrs {
+		c[k] = v
+	}
+	for k, v := range others {
+		c[k] = v
+	}
+	return c
+}
+
+// dotLexer splits DOT source into tokens, skipping the comments.
+type dotLexer struct {
+	src string
+	pos int
+}
+
+// line returns the line that the lexer is on.
+func (l *dotLexer) line() int {
+	return strings.Count(l.src[:l.pos], "\n") + 1
+}
+
+// next returns the next token, or an empty (unquoted) one at the end of the source.
+func (l *dotLexer) next() (dotToken, error) {
+	src := l.src
+	for l.pos < len(src) {
+		switch rest := src[l.pos:]; {
+		case unicode.IsSpace(rune(src[l.pos])):
+			l.pos++
+		case strings.HasPrefix(rest, "//") || rest[0] == '#':
+			if i := strings.IndexByte(rest, '\n'); i != -1 {
+				l.pos += i
+			} else {
+				l.pos = len(src)
+			}
+		case strings.HasPrefix(rest, "/*"):
+			i := strings.Index(rest, "*/")
+			if i == -1 {
+				return dotToken{}, errors.New("unterminated comment")
+			}
+			l.pos += i + 2
+		default:
+			return l.token()
+		}
+	}
+	return dotToken{}, nil
+}
+
+func (l *dotLexer) token() (dotToken, error) {
+	src, start := l.src, l.pos
+	switch c := src[l.pos]; {
+	case c == '"':
+		var b strings.Builder
+		for l.pos++; l.pos < len(src); l.pos++ {
+			switch src[l.pos] {
+			case '\\':
+				if l.pos+1 < len(src) && src[l.pos+1] == '"' {
+					l.pos++
+				}
+			case '"':
+				l.pos++
+				return dotToken{text: b.String(), quoted: true}, nil
+			}
+			b.WriteByte(src[l.pos])
+		}
+		return dotToken{}, errors.New("unterminated string")
+	case c == '<':
+		return dotToken{}, errors.New("HTML labels aren't supported")
+	case strings.HasPrefix(src[l.pos:], "->"), strings.HasPrefix(src[l.pos:], "--"):
+		l.pos += 2
+	case strings.IndexByte("{}[];,=:", c) != -1:
+		l.pos++
+	default:
+		for l.pos < len(src) && isDOTIDChar(src[l.pos]) {
+			l.pos++
+		}
+		if l.pos == start {
+			return dotToken{}, fmt.Errorf("unexpected '%c'", c)
+		}
+	}
+	return dotToken{text: src[start:l.pos]}, nil
+}
+
+func isDOTIDChar(c byte) bool {
+	return c == '_' || c == '.' || c >= 0x80 || c >= '0' && c <= '9' || isASCIILetter(c)
+}
+
+// layout draws the graph, which is arranged the first time that it's laid out (and again if
+// the metric or the text size change).
+func (g *dotGraph) layout(gtx C, th *material.Theme) D {
+	if !g.arranged || g.metric != gtx.Metric || g.textSize != th.TextSize {
+		g.arrange(gtx, th)
+	}
+	pen := newDiagramPen(th)
+	for _, e := range g.edges {
+		dashed := e.attrs["style"] == "dashed" || e.attrs["style"] == "dotted"
+		var mid, head, tail f32.Point
+		if e.from == e.to {
+			// A loop goes out of the right side and back in.
+			n := e.from
+			right := layout.FPt(n.center.Add(image.Point{n.size.X / 2, 0}))
+			out, in := right.Add(f32.Pt(0, -float32(n.size.Y)/5)), right.Add(f32.Pt(0, float32(n.size.Y)/5))
+			far := float32(g.loop)
+			pen.line(gtx, out, out.Add(f32.Pt(far, 0)), dashed)
+			pen.line(gtx, out.Add(f32.Pt(far, 0)), in.Add(f32.Pt(far, 0)), dashed)
+			pen.line(gtx, in.Add(f32.Pt(far, 0)), in, dashed)
+			head, tail = in, in.Add(f32.Pt(far, 0))
+			mid = right.Add(f32.Pt(far+float32(e.labelSize.X)/2+float32(gtx.Dp(4)), 0))
+		} else {
+			a, b := layout.FPt(e.from.center), layout.FPt(e.to.center)
+			tail, head = e.from.border(b), e.to.border(a)
+			pen.line(gtx, tail, head, dashed)
+			mid = tail.Add(head).Mul(0.5)
+		}
+		if g.directed && e.attrs["dir"] != "none" {
+			pen.arrowHead(gtx, head, tail, false)
+		}
+		if l, ok := e.attrs["label"]; ok && e.labelSize != (image.Point{}) {
+			pen.drawText(gtx, pen.text(gtx, diagramLabel(l)), image.Point{int(mid.X), int(mid.Y)}, true)
+		}
+	}
+	for _, n := range g.nodes {
+		r := image.Rectangle{Min: n.center.Sub(n.size.Div(2)), Max: n.center.Add(n.size.Div(2))}
+		switch n.shape() {
+		case "", "ellipse", "oval", "circle":
+			pen.ellipse(gtx, r)
+		case "doublecircle":
+			pen.ellipse(gtx, r)
+			pen.ellipse(gtx, r.Inset(gtx.Dp(4)))
+		case "point":
+			pen.fill = pen.fg
+			pen.ellipse(gtx, r)
+			pen.fill = newDiagramPen(th).fill
+			continue
+		case "diamond":
+			pen.diamond(gtx, r)
+		case "plaintext", "plain", "none":
+		default:
+			radius := 0
+			if strings.Contains(n.attrs["style"], "rounded") {
+				radius = gtx.Dp(6)
+			}
+			pen.box(gtx, r, radius, pen.fill)
+		}
+		pen.drawText(gtx, pen.text(gtx, n.label()), n.center, false)
+	}
+	return D{Size: g.size}
+}
+
+// arrange works out the sizes and positions of the nodes (which are already ranked and put in
+// order), laying the graph out in ranks like Graphviz's dot does, although much more simply:
+// each node goes one rank after the furthest of the nodes with edges to it, the nodes of each
+// rank are put in order of where their neighbors are, and the edges are straight lines.
+func (g *dotGraph) arrange(gtx C, th *material.Theme) {
+	g.arranged, g.metric, g.textSize = true, gtx.Metric, th.TextSize
+	// The text is only laid out here to be measured (and is laid out again to be drawn).
+	pen := newDiagramPen(th)
+	pad := image.Point{gtx.Dp(12), gtx.Dp(7)}
+	for _, n := range g.nodes {
+		size := pen.text(gtx, n.label()).size.Add(pad.Mul(2))
+		switch n.shape() {
+		case "", "ellipse", "oval":
+			size = image.Point{size.X * 13 / 10, size.Y * 5 / 4}
+		case "circle", "doublecircle":
+			d := max(size.X, size.Y) * 11 / 10
+			size = image.Point{d, d}
+		case "diamond":
+			size = size.Mul(9).Div(5)
+		case "point":
+			size = image.Point{gtx.Dp(6), gtx.Dp(6)}
+		}
+		n.size = size
+	}
+	var labelSize image.Point
+	for i := range g.edges {
+		e := &g.edges[i]
+		if l, ok := e.attrs["label"]; ok {
+			e.labelSize = pen.text(gtx, diagramLabel(l)).size
+			labelSize.X = max(labelSize.X, e.labelSize.X)
+			labelSize.Y = max(labelSize.Y, e.labelSize.Y)
+		}
+	}
+
+	// The layout is worked out from top to bottom, with "along" being down the ranks and
+	// "across" being along each rank, and then turned for the other directions.
+	sideways := g.rankdir == "LR" || g.rankdir == "RL"
+	along := func(p image.Point) int {
+		if sideways {
+			return p.X
+		}
+		return p.Y
+	}
+	across := func(p image.Point) int {
+		if sideways {
+			return p.Y
+		}
+		return p.X
+	}
+	rankGap := gtx.Dp(40) + along(labelSize)
+	nodeGap := gtx.Dp(24)
+	if sideways {
+		nodeGap = gtx.Dp(16)
+	}
+	rankWidths := make([]int, len(g.ranks))
+	var width int
+	for r, rank := range g.ranks {
+		for i, n := range rank {
+			if i > 0 {
+				rankWidths[r] += nodeGap
+			}
+			rankWidths[r] += across(n.size)
+		}
+		width = max(width, rankWidths[r])
+	}
+	var length int
+	for r, rank := range g.ranks {
+		depth := 0
+		for _, n := range rank {
+			depth = max(depth, along(n.size))
+		}
+		if r > 0 {
+			length += rankGap
+		}
+		x := (width - rankWidths[r]) / 2
+		for _, n := range rank {
+			a, b := length+depth/2, x+across(n.size)/2
+			switch g.rankdir {
+			case "LR":
+				n.center = image.Point{a, b}
+			case "RL":
+				n.center = image.Point{-a, b}
+			case "BT":
+				n.center = image.Point{b, -a}
+			default:
+				n.center = image.Point{b, a}
+			}
+			x += across(n.size) + nodeGap
+		}
+		length += depth
+	}
+
+	// Move it all to within the bounds of its size, with room for any loops.
+	var bounds image.Rectangle
+	g.loop = gtx.Dp(18)
+	for i, n := range g.nodes {
+		r := image.Rectangle{Min: n.center.Sub(n.size.Div(2)), Max: n.center.Add(n.size.Div(2))}
+		if i == 0 {
+			bounds = r
+		}
+		bounds = bounds.Union(r)
+	}
+	for _, e := range g.edges {
+		if e.from == e.to {
+			bounds.Max.X = max(bounds.Max.X, e.from.center.X+e.from.size.X/2+g.loop+labelSize.X)
+		}
+	}
+	margin := gtx.Dp(2)
+	for _, n := range g.nodes {
+		n.center = n.center.Sub(bounds.Min).Add(image.Point{margin, margin})
+	}
+	g.size = bounds.Size().Add(image.Point{2 * margin, 2 * margin})
+}
+
+// rank puts each node in the rank after the furthest of the nodes with edges to it, and
+// returns the nodes of each rank. The edges that would make a cycle are taken as going the
+// other way.
+func (g *dotGraph) rank() [][]*dotNode {
+	index := make(map[*dotNode]int, len(g.nodes))
+	for i, n := range g.nodes {
+		index[n] = i
+	}
+	succ := make([][]int, len(g.nodes))
+	for _, e := range g.edges {
+		if e.from != e.to {
+			succ[index[e.from]] = append(succ[index[e.from]], index[e.to])
+		}
+	}
+	// A depth first search finds the edges back to the nodes that it's within.
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(g.nodes))
+	dag := make([][]int, len(g.nodes))
+	indegree := make([]int, len(g.nodes))
+	var visit func(i int)
+	visit = func(i int) {
+		state[i] = visiting
+		for _, j := range succ[i] {
+			if state[j] == visiting {
+				dag[j] = append(dag[j], i)
+				indegree[i]++
+				continue
+			}
+			dag[i] = append(dag[i], j)
+			indegree[j]++
+			if state[j] == unvisited {
+				visit(j)
+			}
+		}
+		state[i] = visited
+	}
+	for i := range g.nodes {
+		if state[i] == unvisited {
+			visit(i)
+		}
+	}
+
+	ranks := make([]int, len(g.nodes))
+	var queue []int
+	for i, d := range indegree {
+		if d == 0 {
+			queue = append(queue, i)
+		}
+	}
+	for len(queue) > 0 {
+		i := queue[0]
+		queue = queue[1:]
+		for _, j := range dag[i] {
+			ranks[j] = max(ranks[j], ranks[i]+1)
+			if indegree[j]--; indegree[j] == 0 {
+				queue = append(queue, j)
+			}
+		}
+	}
+	var byRank [][]*dotNode
+	for i, n := range g.nodes {
+		for len(byRank) <= ranks[i] {
+			byRank = append(byRank, nil)
+		}
+		n.rank = ranks[i]
+		n.order = len(byRank[ranks[i]])
+		byRank[ranks[i]] = append(byRank[ranks[i]], n)
+	}
+	return byRank
+}
+
+// order puts the nodes of each rank in order of the average position of their neighbors in
+// the ranks before them (and then after them, and so on a few times), which cuts down on how
+// many edges cross.
+func (g *dotGraph) order(ranks [][]*dotNode) {
+	neighbors := make(map[*dotNode][]*dotNode)
+	for _, e := range g.edges {
+		if e.from != e.to {
+			neighbors[e.from] = append(neighbors[e.from], e.to)
+			neighbors[e.to] = append(neighbors[e.to], e.from)
+		}
+	}
+	for sweep := 0; sweep < 4; sweep++ {
+		down := sweep%2 == 0
+		for i := range ranks {
+			r := i
+			if !down {
+				r = len(ranks) - 1 - i
+			}
+			rank := ranks[r]
+			centers := make(map[*dotNode]float64, len(rank))
+			for _, n := range rank {
+				sum, count := 0.0, 0
+				for _, m := range neighbors[n] {
+					if down && m.rank < r || !down && m.rank > r {
+						sum += float64(m.order)
+						count++
+					}
+				}
+				if count > 0 {
+					centers[n] = sum / float64(count)
+				} else {
+					centers[n] = float64(n.order)
+				}
+			}
+			sort.SliceStable(rank, func(i, j int) bool {
+				return centers[rank[i]] < centers[rank[j]]
+			})
+			for i, n := range rank {
+				n.order = i
+			}
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"image/color"
 	"math"
 	"strconv"
+	"strings"
 
 	"gioui.org/gesture"
 	"gioui.org/io/key"
@@ -22,14 +23,23 @@ type mode byte
 const (
 	modeNormal mode = iota
 	modeInsert
+	modeVisual
+	modeVisualLine
 )
 
 type Editor struct {
-	buf     buffer
-	mode    mode
-	pending command
-	active  action
-	history []action
+	// AutoPairs are the delimiters that get closed automatically when typed.
+	AutoPairs AutoPairs
+	// LineNumbers is how line numbers are displayed in the gutter.
+	LineNumbers LineNumbers
+
+	buf         buffer
+	mode        mode
+	pending     command
+	active      action
+	history     []action
+	visualStart position
+	autoClosed  []autoClose
 
 	eventKey byte
 	click    gesture.Click
@@ -47,6 +57,7 @@ type Editor struct {
 	lnNumSpace  int
 	highlighter highlighter
 	styleMarks  [][]mdStyleMark
+	signs       [numSignColumns]map[int]Sign
 }
 
 type highlighter interface {
@@ -62,6 +73,9 @@ func (ed *Editor) Layout(gtx C, sh text.Shaper, fnt text.Font, txtSize unit.Sp,
 		if e.Type == gesture.TypePress {
 			ed.reqFocus = true
 		}
+		if e.Type == gesture.TypeClick && e.Modifiers == key.ModCtrl {
+			ed.addCursorAtPoint(e.Position.Sub(image.Point{X: gtx.Dp(5)}))
+		}
 	}
 	if ed.reqFocus {
 		key.FocusOp{Tag: &ed.eventKey}.Add(gtx.Ops)
@@ -76,7 +90,7 @@ func (ed *Editor) Layout(gtx C, sh text.Shaper, fnt text.Font, txtSize unit.Sp,
 
 func (ed *Editor) processEvents(gtx C) {
 	const keySet = "A|B|C|D|E|F|G|H|I|J|K|L|M|N|O|P|Q|R|S|T|U|V|W|U|X|Y|Z" +
-		"|" + "Ctrl-[E,R,S]" +
+		"|" + "Ctrl-[A,E,L,N,R,S,T,X," + key.NameUpArrow + "," + key.NameDownArrow + "]" +
 		"|" + key.NameDeleteBackward + "|" + key.NameDeleteForward +
 		"|" + key.NameLeftArrow + "|" + key.NameRightArrow +
 		"|" + key.NameUpArrow + "|" + key.NameDownArrow +
@@ -85,12 +99,14 @@ func (ed *Editor) processEvents(gtx C) {
 		"|" + key.NameReturn
 
 	key.InputOp{Tag: &ed.eventKey, Keys: keySet}.Add(gtx.Ops)
+	numLines, row := len(ed.buf.lines), ed.buf.cursor.row
 	switch ed.mode {
-	case modeNormal:
+	case modeNormal, modeVisual, modeVisualLine:
 		ed.processNormalEvents(gtx)
 	case modeInsert:
 		ed.processInsertEvents(gtx)
 	}
+	ed.updateFolds(numLines, row)
 }
 
 func (ed *Editor) processNormalEvents(gtx C) {
@@ -103,31 +119,139 @@ func (ed *Editor) processNormalEvents(gtx C) {
 			switch e.Modifiers {
 			case key.ModCtrl:
 				switch e.Name {
+				case "A", "X":
+					n := max(1, ed.pending.motionCount)
+					if e.Name == "X" {
+						n = -n
+					}
+					ed.increment(n, ed.pending.modChar == 'g')
+					ed.pending = command{}
 				case "E":
 					ed.buf.scrollVision(1)
+				case "L":
+					ed.cycleLineNumbers()
+				case "N":
+					ed.addCursorsAtMatches()
+				case key.NameUpArrow, key.NameDownArrow:
+					if ed.mode == modeNormal {
+						ed.buf.addCursorVertically(e.Name == key.NameDownArrow)
+					}
 				case "R":
 					// TODO redo?
 				case "S":
 					ed.reqSave = true
+				case "T":
+					ed.updateTOC()
 				}
 			case 0:
+				if e.Name == key.NameEscape {
+					ed.pending = command{}
+					ed.mode = modeNormal
+					ed.clearCursors()
+					break
+				}
+				if e.Name == key.NameDeleteForward && (ed.pending.motionCount != 0 || ed.pending.motionChar1 != 0) {
+					ed.pending = command{}
+					break
+				}
+				ed.buf.eachCursor(func() {
+					switch e.Name {
+					case key.NameDeleteBackward:
+						it := newIter(&ed.buf)
+						it.step(iterBackward)
+						ed.buf.cursor = it.position()
+						ed.buf.prefCol = ed.buf.cursor.col
+					case key.NameDeleteForward:
+						ed.exec(&command{cmdChar: 'x'})
+					case key.NameLeftArrow:
+						ed.buf.cursor.col = max(0, ed.buf.cursor.col-1)
+						ed.buf.prefCol = ed.buf.cursor.col
+					case key.NameRightArrow:
+						ed.buf.cursor.col = min(ed.buf.cursor.col+1, ed.buf.currLineLen()-1)
+						ed.buf.prefCol = ed.buf.cursor.col
+					case key.NameUpArrow:
+						if ed.buf.cursor.row > 0 {
+							ed.buf.cursor.row--
+							ed.buf.clampCol(ed.mode == modeNormal)
+						}
+					case key.NameDownArrow:
+						if ed.buf.cursor.row < len(ed.buf.lines)-1 {
+							ed.buf.cursor.row++
+							ed.buf.clampCol(ed.mode == modeNormal)
+						}
+					case key.NameHome:
+						ed.buf.cursor.col = 0
+						ed.buf.prefCol = 0
+					case key.NameEnd:
+						ed.buf.cursor.col = max(0, ed.buf.currLineLen()-1)
+						ed.buf.prefCol = -1
+					case key.NameReturn:
+						ed.buf.cursor.row = min(ed.buf.cursor.row+1, len(ed.buf.lines)-1)
+						ed.buf.cursor.col = ed.buf.currentLine().startingIndex()
+						ed.buf.prefCol = ed.buf.cursor.col
+					}
+				})
+			}
+		case key.EditEvent:
+			if ed.mode != modeNormal && ed.visualEdit(e.Text[0]) {
+				ed.pending = command{}
+				ed.highlight()
+				ed.changed = true
+				break
+			}
+			ed.pending.process(e.Text[0])
+			if ed.pending.cmdChar != 0 || ed.pending.hasMotion() {
+				ed.execAll(&ed.pending)
+				ed.active.cmd = ed.pending
+				ed.pending = command{}
+			}
+		}
+		ed.buf.mvViewIntoCursor()
+	}
+}
+
+func (ed *Editor) processInsertEvents(gtx C) {
+	for _, e := range gtx.Events(&ed.eventKey) {
+		switch e := e.(type) {
+		case key.Event:
+			if e.State != key.Press {
+				continue
+			}
+			// Any auto pairs are only kept track of while typing and deleting characters,
+			// not once the cursor is moved or leaves the line.
+			switch e.Name {
+			case key.NameEscape, key.NameLeftArrow, key.NameRightArrow, key.NameUpArrow,
+				key.NameDownArrow, key.NameHome, key.NameEnd, key.NameReturn:
+				ed.autoClosed = ed.autoClosed[:0]
+			}
+			if e.Name == key.NameEscape {
+				ed.exitInsertMode()
+				break
+			}
+			// Auto pairs are only kept track of for a single cursor.
+			paired := len(ed.buf.cursors) == 0
+			ed.buf.eachCursor(func() {
 				switch e.Name {
 				case key.NameDeleteBackward:
-					it := newIter(&ed.buf)
-					it.step(iterBackward)
-					ed.buf.cursor = it.position()
-					ed.buf.prefCol = ed.buf.cursor.col
-				case key.NameDeleteForward:
-					if ed.pending.motionCount != 0 || ed.pending.motionChar1 != 0 {
-						ed.pending = command{}
-					} else {
-						ed.exec(&command{cmdChar: 'x'})
+					switch {
+					case paired && ed.deletePair():
+					case ed.buf.cursor.col == 0:
+						ed.autoClosed = ed.autoClosed[:0]
+						ed.buf.deleteBack()
+					default:
+						ed.buf.deleteBack()
+						ed.shiftAutoClosed(-1)
 					}
+					ed.changed = true
+				case key.NameDeleteForward:
+					ed.deleteForwardAutoClosed()
+					ed.buf.deleteForwardInsert()
+					ed.changed = true
 				case key.NameLeftArrow:
 					ed.buf.cursor.col = max(0, ed.buf.cursor.col-1)
 					ed.buf.prefCol = ed.buf.cursor.col
 				case key.NameRightArrow:
-					ed.buf.cursor.col = min(ed.buf.cursor.col+1, ed.buf.currLineLen()-1)
+					ed.buf.cursor.col = min(ed.buf.cursor.col+1, ed.buf.currLineLen())
 					ed.buf.prefCol = ed.buf.cursor.col
 				case key.NameUpArrow:
 					if ed.buf.cursor.row > 0 {
@@ -143,92 +267,67 @@ func (ed *Editor) processNormalEvents(gtx C) {
 					ed.buf.cursor.col = 0
 					ed.buf.prefCol = 0
 				case key.NameEnd:
-					ed.buf.cursor.col = max(0, ed.buf.currLineLen()-1)
+					ed.buf.cursor.col = max(0, ed.buf.currLineLen())
 					ed.buf.prefCol = -1
-				case key.NameEscape:
-					ed.pending = command{}
 				case key.NameReturn:
-					ed.buf.cursor.row = min(ed.buf.cursor.row+1, len(ed.buf.lines)-1)
-					ed.buf.cursor.col = ed.buf.currentLine().startingIndex()
-					ed.buf.prefCol = ed.buf.cursor.col
+					ed.buf.insertContinuedNewLine()
+					ed.changed = true
 				}
-			}
-		case key.EditEvent:
-			ed.pending.process(e.Text[0])
-			if ed.pending.cmdChar != 0 || ed.pending.hasMotion() {
-				ed.exec(&ed.pending)
-				ed.active.cmd = ed.pending
-				ed.pending = command{}
-			}
-		}
-		ed.buf.mvViewIntoCursor()
-	}
-}
-
-func (ed *Editor) processInsertEvents(gtx C) {
-	for _, e := range gtx.Events(&ed.eventKey) {
-		switch e := e.(type) {
-		case key.Event:
-			if e.State != key.Press {
-				continue
-			}
+			})
 			switch e.Name {
-			case key.NameDeleteBackward:
-				ed.buf.deleteBack()
-				ed.highlight()
-				ed.changed = true
-			case key.NameDeleteForward:
-				ed.buf.deleteForwardInsert()
-				ed.highlight()
-				ed.changed = true
-			case key.NameLeftArrow:
-				ed.buf.cursor.col = max(0, ed.buf.cursor.col-1)
-				ed.buf.prefCol = ed.buf.cursor.col
-			case key.NameRightArrow:
-				ed.buf.cursor.col = min(ed.buf.cursor.col+1, ed.buf.currLineLen())
-				ed.buf.prefCol = ed.buf.cursor.col
-			case key.NameUpArrow:
-				if ed.buf.cursor.row > 0 {
-					ed.buf.cursor.row--
-					ed.buf.clampCol(ed.mode == modeNormal)
-				}
-			case key.NameDownArrow:
-				if ed.buf.cursor.row < len(ed.buf.lines)-1 {
-					ed.buf.cursor.row++
-					ed.buf.clampCol(ed.mode == modeNormal)
-				}
-			case key.NameHome:
-				ed.buf.cursor.col = 0
-				ed.buf.prefCol = 0
-			case key.NameEnd:
-				ed.buf.cursor.col = max(0, ed.buf.currLineLen())
-				ed.buf.prefCol = -1
-			case key.NameReturn:
-				ed.buf.insertNewLine()
+			case key.NameDeleteBackward, key.NameDeleteForward, key.NameReturn:
 				ed.highlight()
-				ed.changed = true
-			case key.NameEscape:
-				ed.exitInsertMode()
 			}
 		case key.EditEvent:
-			ed.buf.insert(e.Text)
+			paired := len(ed.buf.cursors) == 0 && len(e.Text) == 1
+			ed.buf.eachCursor(func() {
+				if !paired || !ed.typePaired(e.Text[0]) {
+					ed.buf.insert(e.Text)
+					if strings.Contains(e.Text, "\n") {
+						ed.autoClosed = ed.autoClosed[:0]
+					} else {
+						ed.shiftAutoClosed(len(e.Text))
+					}
+				}
+			})
 			ed.changed = true
 		}
 	}
 }
 
 func (ed *Editor) exitInsertMode() {
-	ed.buf.cursor.col = max(0, ed.buf.cursor.col-1)
-	ed.buf.prefCol = ed.buf.cursor.col
+	ed.buf.eachCursor(func() {
+		ed.buf.cursor.col = max(0, ed.buf.cursor.col-1)
+		ed.buf.prefCol = ed.buf.cursor.col
+	})
 	ed.mode = modeNormal
 	ed.history = append(ed.history, ed.active)
 	ed.active = action{}
 }
 
+// execAll executes the command at every cursor. Extra cursors are dropped when in (or
+// going into) visual mode though, since a selection only has one starting point.
+func (ed *Editor) execAll(c *command) {
+	if ed.mode != modeNormal || c.cmdChar == 'v' || c.cmdChar == 'V' {
+		ed.clearCursors()
+	}
+	ed.buf.eachCursor(func() {
+		ed.exec(c)
+	})
+}
+
 func (ed *Editor) exec(c *command) {
-	if c.modChar == 'g' {
+	switch c.modChar {
+	case 'g':
 		ed.gExec(c)
 		return
+	case 'z':
+		ed.zExec(c)
+		return
+	}
+	if ed.mode == modeVisual || ed.mode == modeVisualLine {
+		ed.visualExec(c)
+		return
 	}
 	switch c.opChar {
 	case 0:
@@ -261,10 +360,15 @@ func (ed *Editor) exec(c *command) {
 			ed.buf.truncCurrentLineFromCursor()
 			ed.mode = modeInsert
 			ed.changed = true
+		case 'v', 'V':
+			ed.toggleVisual(c.cmdChar)
 		}
 	case 'd':
 		ed.del(c)
 		ed.changed = true
+	case '>', '<':
+		ed.shift(c)
+		ed.changed = true
 	case 'y':
 		// TODO yank whatever motion covers
 	case 'P':
@@ -274,6 +378,91 @@ func (ed *Editor) exec(c *command) {
 	}
 }
 
+func (ed *Editor) visualExec(c *command) {
+	switch c.cmdChar {
+	case 0:
+		ed.movement(c)
+	case 'v', 'V':
+		ed.toggleVisual(c.cmdChar)
+	}
+}
+
+// visualEdit handles the characters that act on the visual mode selection as soon as they
+// are typed, which are the shift operators and any auto pair delimiters (which wrap the
+// selection). It returns false if the character wasn't handled.
+func (ed *Editor) visualEdit(char byte) bool {
+	if ed.pending.opChar != 0 || ed.pending.modChar != 0 {
+		return false
+	}
+	switch char {
+	case '>', '<':
+		start, end := ed.selection()
+		for i := 0; i < max(1, ed.pending.motionCount); i++ {
+			ed.buf.shiftLines(start.row, end.row, char == '>')
+		}
+		ed.mode = modeNormal
+		return true
+	}
+	if ed.pending.motionCount != 0 {
+		return false
+	}
+	return ed.wrapSelection(char)
+}
+
+// toggleVisual enters the visual mode for the given command character ('v' for charwise
+// and 'V' for linewise). If already in that visual mode, it goes back to normal mode.
+func (ed *Editor) toggleVisual(cmdChar byte) {
+	m := modeVisual
+	if cmdChar == 'V' {
+		m = modeVisualLine
+	}
+	switch ed.mode {
+	case m:
+		ed.mode = modeNormal
+	case modeNormal:
+		ed.visualStart = ed.buf.cursor
+		fallthrough
+	default:
+		ed.mode = m
+	}
+}
+
+// selection returns the start and (exclusive) end positions of the visual mode selection.
+// A linewise selection spans from the first non-blank character of its first line to the
+// end of its last line.
+func (ed *Editor) selection() (start, end position) {
+	start, end = ed.visualStart, ed.buf.cursor
+	if end.before(start) {
+		start, end = end, start
+	}
+	if ed.mode == modeVisualLine {
+		start.col = ed.buf.lines[start.row].startingIndex()
+		end.col = len(ed.buf.lines[end.row].text)
+		return start, end
+	}
+	end.col = min(end.col+1, len(ed.buf.lines[end.row].text))
+	return start, end
+}
+
+// selectedCols returns the range of columns that are selected on the given row.
+func (ed *Editor) selectedCols(row int) (int, int, bool) {
+	if ed.mode != modeVisual && ed.mode != modeVisualLine {
+		return 0, 0, false
+	}
+	start, end := ed.selection()
+	if row < start.row || row > end.row {
+		return 0, 0, false
+	}
+	c1, c2 := 0, len(ed.buf.lines[row].text)
+	if row == start.row {
+		c1 = start.col
+	}
+	if row == end.row {
+		c2 = end.col
+	}
+	return c1, c2, true
+}
+
 func (ed *Editor) movement(c *command) {
 	if c.motionChar1 == '0' {
 		ed.buf.cursor.col = 0
@@ -318,6 +507,56 @@ func (ed *Editor) gExec(c *command) {
 	}
 }
 
+// increment adds n to the number (or n days to the ISO date) under or after the cursor. In
+// visual mode, the first number on each selected line is incremented instead, and if
+// progressive is true, each line gets incremented by n more than the one before it.
+func (ed *Editor) increment(n int, progressive bool) {
+	if ed.mode == modeNormal {
+		if col, ok := ed.buf.currentLine().increment(ed.buf.cursor.col, -1, n); ok {
+			ed.buf.cursor.col = col
+			ed.buf.prefCol = col
+			ed.highlight()
+			ed.changed = true
+		}
+		return
+	}
+	start, end := ed.selection()
+	step := n
+	for row := start.row; row <= end.row; row++ {
+		c1, c2, _ := ed.selectedCols(row)
+		if _, ok := ed.buf.lines[row].increment(c1, c2, n); ok && progressive {
+			n += step
+		}
+	}
+	ed.buf.cursor = start
+	ed.buf.prefCol = start.col
+	ed.mode = modeNormal
+	ed.highlight()
+	ed.changed = true
+}
+
+// shift shifts the lines covered by the command's motion (or, for `>>` and `<<`, the
+// current line and count-1 lines below it).
+func (ed *Editor) shift(c *command) {
+	it := newIter(&ed.buf)
+	n := c.motionCount
+	switch c.motionChar1 {
+	case c.opChar:
+		it.seekByY(max(1, n) - 1)
+	case 'j':
+		it.seekByY(max(1, n))
+	case 'k':
+		it.seekByY(0 - max(1, n))
+	case 'H':
+		it.seekNthLineFromTop(max(n-1, 0))
+	case 'L':
+		it.seekNthLineFromBot(max(n-1, 0))
+	}
+	y1, y2 := it.yBounds()
+	ed.buf.shiftLines(y1, y2, c.opChar == '>')
+	ed.highlight()
+}
+
 func (ed *Editor) del(c *command) {
 	it := newIter(&ed.buf)
 	n := c.motionCount
@@ -348,16 +587,15 @@ func (ed *Editor) del(c *command) {
 
 func (ed *Editor) layLines(gtx C) D {
 	numBufLines := len(ed.buf.lines)
-	botIndex := ed.buf.vision.y + ed.buf.vision.h
 	textSize := fixed.I(gtx.Sp(ed.textSize))
+	folds := ed.buf.closedFolds()
 	yOffset := 0
+	numRows := 0
 	// Draw each visible line of text.
-	for row := ed.buf.vision.y; row < min(numBufLines, botIndex); row++ {
+	for row := ed.buf.vision.y; row < numBufLines && numRows < ed.buf.vision.h; row++ {
 		gtx.Constraints.Min = image.Point{}
 		vertOffset := op.Offset(image.Point{Y: yOffset}).Push(gtx.Ops)
-		ed.drawLineNumber(gtx, textSize, row)
-
-		xOffset := ed.lnNumSpace + ed.charWidth // Start the line's text after the line number.
+		xOffset := ed.drawGutter(gtx, textSize, row) // Start the line's text after the gutter.
 		line := ed.buf.lines[row].text
 
 		var marks []mdStyleMark
@@ -366,6 +604,17 @@ func (ed *Editor) layLines(gtx C) D {
 		}
 		nextMarkIndex := 0
 		fg, fnt := ed.styleBreakdown(nil)
+		strike := false
+		selBegin, selEnd, hasSel := ed.selectedCols(row)
+		cursorCols := ed.buf.cursorCols(row)
+		isCursor := func(col int) bool {
+			for _, c := range cursorCols {
+				if c == col {
+					return true
+				}
+			}
+			return false
+		}
 
 		segBegin := 0
 		for {
@@ -374,6 +623,7 @@ func (ed *Editor) layLines(gtx C) D {
 			// index set to the next marker).
 			for nextMarkIndex < len(marks) && marks[nextMarkIndex].col == segBegin {
 				fg, fnt = ed.styleBreakdown(&marks[nextMarkIndex])
+				strike = marks[nextMarkIndex].value&mdStrikethrough != 0
 				nextMarkIndex++
 			}
 			// The segment always starts out as the rest of the line. If there is a 'next'
@@ -382,17 +632,29 @@ func (ed *Editor) layLines(gtx C) D {
 			if nextMarkIndex < len(marks) {
 				segEnd = marks[nextMarkIndex].col
 			}
-			// If the cursor is within the current segment, then truncate the current
+			// If a cursor is within the current segment, then truncate the current
 			// segment to right before the cursor position (since the cursor will have
 			// different styling then the rest of the surrounding segment).
-			if ed.buf.cursor.row == row && ed.buf.cursor.col > segBegin && ed.buf.cursor.col < segEnd {
-				segEnd = ed.buf.cursor.col
+			for _, col := range cursorCols {
+				if col > segBegin && col < segEnd {
+					segEnd = col
+				}
+			}
+			// Segments also don't span across either edge of a visual mode selection.
+			if hasSel {
+				if selBegin > segBegin && selBegin < segEnd {
+					segEnd = selBegin
+				}
+				if selEnd > segBegin && selEnd < segEnd {
+					segEnd = selEnd
+				}
 			}
 			// If the current segment end make no sense, these markers are tossed.
 			if n := len(line); segEnd > n {
 				segEnd = n
 				ed.styleMarks = nil
 				fg, fnt = ed.styleBreakdown(nil)
+				strike = false
 			}
 			// If the beginning of the segement is at or past the end, then we're
 			// certainly done with this line.
@@ -401,33 +663,59 @@ func (ed *Editor) layLines(gtx C) D {
 			}
 
 			xOffsetOp := op.Offset(image.Point{X: xOffset}).Push(gtx.Ops)
-			if ed.buf.cursor.is(row, segBegin) {
+			if isCursor(segBegin) {
 				segEnd = segBegin + 1
 				rect := clip.Rect{Max: image.Point{ed.charWidth, ed.lnHeight}}
 				paint.FillShape(gtx.Ops, fg, rect.Op())
 				paint.ColorOp{Color: ed.palette.Bg}.Add(gtx.Ops)
 			} else {
+				if hasSel && segBegin >= selBegin && segBegin < selEnd {
+					rect := clip.Rect{Max: image.Point{(segEnd - segBegin) * ed.charWidth, ed.lnHeight}}
+					paint.FillShape(gtx.Ops, ed.palette.Selection, rect.Op())
+				}
 				paint.ColorOp{Color: fg}.Add(gtx.Ops)
 			}
 			seg := string(line[segBegin:segEnd])
 			segDims := drawText(gtx, ed.shaper, fnt, textSize, seg)
+			if strike {
+				// Fonts don't have strikethrough, so a line is drawn across the text.
+				y := ed.lnHeight / 2
+				rect := clip.Rect{Min: image.Point{0, y}, Max: image.Point{segDims.Size.X, y + max(1, gtx.Dp(1))}}
+				paint.FillShape(gtx.Ops, fg, rect.Op())
+			}
 			xOffsetOp.Pop()
 
 			xOffset += segDims.Size.X
 			segBegin = segEnd
 		}
 		// Draw the cursor if it's after the last character on the line.
-		if ed.buf.cursor.is(row, segBegin) {
+		if isCursor(segBegin) {
 			xOffsetOp := op.Offset(image.Point{X: xOffset}).Push(gtx.Ops)
 			rect := clip.Rect{Max: image.Point{ed.charWidth, gtx.Sp(ed.textSize)}}
 			paint.FillShape(gtx.Ops, ed.palette.Fg, rect.Op())
 			xOffsetOp.Pop()
+		} else if hasSel && len(line) == 0 {
+			// Show empty lines as selected by filling in a character's width.
+			xOffsetOp := op.Offset(image.Point{X: xOffset}).Push(gtx.Ops)
+			rect := clip.Rect{Max: image.Point{ed.charWidth, ed.lnHeight}}
+			paint.FillShape(gtx.Ops, ed.palette.Selection, rect.Op())
+			xOffsetOp.Pop()
+		}
+		// A closed fold is summarized by its first line followed by the number of lines
+		// that are hidden, and the rest of it is skipped over.
+		if f, ok := foldAt(folds, row); ok {
+			xOffsetOp := op.Offset(image.Point{X: xOffset + 2*ed.charWidth}).Push(gtx.Ops)
+			paint.ColorOp{Color: ed.palette.LineNumber}.Add(gtx.Ops)
+			drawText(gtx, ed.shaper, ed.font, textSize, "··· "+strconv.Itoa(f.end-f.start)+" lines")
+			xOffsetOp.Pop()
+			row = f.end
 		}
 		vertOffset.Pop()
 		yOffset += ed.lnHeight
+		numRows++
 	}
 	// The blank lines (if any).
-	for row := numBufLines; row < botIndex; row++ {
+	for ; numRows < ed.buf.vision.h; numRows++ {
 		t := op.Offset(image.Point{Y: yOffset}).Push(gtx.Ops)
 		clr := ed.palette.ListMarker
 		clr.A = 100
@@ -455,6 +743,24 @@ func (ed *Editor) styleBreakdown(m *mdStyleMark) (color.NRGBA, text.Font) {
 	if m.value&mdCodeSpan == mdCodeSpan || m.value&mdCodeBlock == mdCodeBlock {
 		fg = ed.palette.CodeBlock
 	}
+	if m.value&mdFrontMatter == mdFrontMatter {
+		fg = ed.palette.FrontMatter
+	}
+	if m.value&mdMath == mdMath {
+		fg = ed.palette.Math
+	}
+	switch {
+	case m.value&mdCodeKeyword != 0:
+		fg = ed.palette.CodeKeyword
+		fnt.Weight = text.Bold
+	case m.value&mdCodeString != 0:
+		fg = ed.palette.CodeString
+	case m.value&mdCodeComment != 0:
+		fg = ed.palette.CodeComment
+		fnt.Style = text.Italic
+	case m.value&mdCodeNumber != 0:
+		fg = ed.palette.CodeNumber
+	}
 	if m.value&mdListMarker == mdListMarker {
 		fg = ed.palette.ListMarker
 		fnt.Weight = text.Bold
@@ -468,22 +774,54 @@ func (ed *Editor) styleBreakdown(m *mdStyleMark) (color.NRGBA, text.Font) {
 		fg = ed.palette.BlockQuote
 		fnt.Style = text.Italic
 	}
+	if m.value&mdSetextHeading == mdSetextHeading {
+		fg = ed.palette.Heading
+	}
+	if m.value&mdTable == mdTable {
+		fg = ed.palette.Table
+	}
+	if m.value&mdStrikethrough == mdStrikethrough {
+		fg = ed.palette.Strikethrough
+	}
+	if m.value&mdFootnote == mdFootnote {
+		fg = ed.palette.Footnote
+	}
+	if m.value&mdTaskDone == mdTaskDone {
+		fg = ed.palette.TaskDone
+	}
+	if m.value&mdTask == mdTask {
+		fnt.Weight = text.Bold
+		if m.value&mdTaskDone == 0 {
+			fg = ed.palette.Task
+		}
+	}
+	if m.value&mdLinkText == mdLinkText {
+		fg = ed.palette.LinkText
+	}
+	if m.value&mdLinkURL == mdLinkURL {
+		fg = ed.palette.LinkURL
+	}
 
 	return fg, fnt
 }
 
 func (ed *Editor) drawLineNumber(gtx C, size fixed.Int26_6, row int) {
 	num := row + 1
-	if row < ed.buf.cursor.row {
-		num = ed.buf.cursor.row - row
-	}
-	if row > ed.buf.cursor.row {
-		num = row - ed.buf.cursor.row
+	if ed.LineNumbers != LineNumbersAbsolute {
+		if row < ed.buf.cursor.row {
+			num = ed.buf.cursor.row - row
+		}
+		if row > ed.buf.cursor.row {
+			num = row - ed.buf.cursor.row
+		}
+		if row == ed.buf.cursor.row && ed.LineNumbers == LineNumbersRelative {
+			num = 0
+		}
 	}
 	numStr := strconv.Itoa(num)
 	gtx.Constraints.Min.X = ed.lnNumSpace
 	paint.ColorOp{Color: ed.palette.LineNumber}.Add(gtx.Ops)
-	if row != ed.buf.cursor.row {
+	if row != ed.buf.cursor.row || ed.LineNumbers != LineNumbersHybrid {
 		// We want inactive line numbers to hug the text (in other words, be aligned
 		// toward the right). So before drawing these line numbers, we offset by what
 		// would be the remaining empty space so that the text will be off to the right.
@@ -503,15 +841,76 @@ func (ed *Editor) SetText(data []byte) {
 
 func (ed *Editor) highlight() {
 	if ed.highlighter == nil {
-		ed.highlighter = mdHighlighter{}
+		ed.highlighter = &astHighlighter{}
 	}
 	ed.styleMarks = ed.highlighter.highlight(&ed.buf)
 }
 
+// useParse has the editor highlight with the marks from a background parse of its text (for
+// whichever of the lines haven't changed since), if its highlighter makes use of them.
+func (ed *Editor) useParse(lines [][]byte, marks [][]mdStyleMark) {
+	if h, ok := ed.highlighter.(*astHighlighter); ok {
+		h.useParse(lines, marks)
+		ed.highlight()
+	}
+}
+
+// toggleTaskAt toggles the checkbox of the task item on the line that contains the given
+// offset into the text.
+func (ed *Editor) toggleTaskAt(off int) {
+	for row := range ed.buf.lines {
+		n := len(ed.buf.lines[row].text) + 1
+		if off < n {
+			ed.buf.lines[row].toggleCheckItem()
+			ed.highlight()
+			ed.changed = true
+			return
+		}
+		off -= n
+	}
+}
+
 func (ed *Editor) Text() []byte {
 	return ed.buf.text()
 }
 
+// MoveToLine moves the cursor to the start of the given line, opening any folds that hide
+// it, and scrolls the line into view.
+func (ed *Editor) MoveToLine(row int) {
+	row = min(max(row, 0), len(ed.buf.lines)-1)
+	ed.buf.cursor = position{row: row}
+	ed.buf.prefCol = 0
+	for {
+		f, ok := foldAt(ed.buf.closedFolds(), row)
+		if !ok || f.start == row {
+			break
+		}
+		delete(ed.buf.folds, f.start)
+	}
+	ed.buf.mvViewIntoCursor()
+}
+
+// CursorLine returns the line that the cursor is on.
+func (ed *Editor) CursorLine() int {
+	return ed.buf.cursor.row
+}
+
+// TopLine returns the line at the top of the editor's view.
+func (ed *Editor) TopLine() int {
+	return ed.buf.vision.y
+}
+
+// ScrollToLine scrolls the editor so that the given line is at the top of its view (or the
+// fold that it's in, if that's closed), keeping the cursor within the view.
+func (ed *Editor) ScrollToLine(row int) {
+	row = min(max(row, 0), len(ed.buf.lines)-1)
+	if f, ok := foldAt(ed.buf.closedFolds(), row); ok {
+		row = f.start
+	}
+	ed.buf.vision.y = row
+	ed.buf.mvCursorIntoView()
+}
+
 func (ed *Editor) Focus() {
 	ed.reqFocus = true
 }
@@ -550,9 +949,11 @@ func (ed *Editor) ensure(gtx C, sh text.Shaper, fnt text.Font, txtSize unit.Sp,
 		ln := sh.LayoutString(fnt, textSize, ed.maxSize.X, gtx.Locale, " ")[0]
 		ed.charWidth = ln.Width.Ceil()
 		ed.lnHeight = ln.Ascent.Ceil() + ln.Descent.Ceil()
-		ed.lnNumSpace = ed.charWidth * max(2, len(strconv.Itoa(len(ed.buf.lines))))
 		ed.buf.vision.h = ed.maxSize.Y / ed.lnHeight
 	}
+	// The line number width depends on the display mode and the number of lines, either of
+	// which can change at any time.
+	ed.lnNumSpace = ed.lineNumberWidth()
 	if ed.palette != pal {
 		ed.palette = pal
 	}
@@ -0,0 +1,231 @@
+package mdedit
+
+import (
+	"image"
+	"unicode/utf8"
+
+	"gioui.org/gesture"
+	"gioui.org/io/pointer"
+	"gioui.org/op"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"gioui.org/text"
+	"gioui.org/widget/material"
+	"gioui.org/x/richtext"
+	"golang.org/x/image/math/fixed"
+)
+
+// flowSpan is what richtext can't show of one of a group's spans. A group with any struck
+// through spans or inline math is laid out by layFlow instead, which draws the former with a
+// line through them and lays out the latter like display math.
+type flowSpan struct {
+	struck bool
+	math   texNode                // the math that's laid out in place of the span's text, if any
+	meta   map[string]interface{} // the span's metadata, which richtext only hands back in its events
+}
+
+// flowState is the state of text that's laid out by layFlow.
+type flowState struct {
+	clicks []gesture.Click // one for each span (of which only the interactive ones are clickable)
+}
+
+// flowSpan returns what layFlow is to know of the current group's span at the given index.
+func (sb *spanBuilder) flowSpan(i int) *flowSpan {
+	if n := i + 1 - len(sb.current.flow); n > 0 {
+		sb.current.flow = append(sb.current.flow, make([]flowSpan, n)...)
+	}
+	return &sb.current.flow[i]
+}
+
+// setMeta sets a key of the metadata of the current group's span at the given index.
+func (sb *spanBuilder) setMeta(i int, key string, value interface{}) {
+	sb.current.items[i].Set(key, value)
+	f := sb.flowSpan(i)
+	if f.meta == nil {
+		f.meta = make(map[string]interface{})
+	}
+	f.meta[key] = value
+}
+
+// strike marks the current group's spans from the first struck through one on as struck.
+func (sb *spanBuilder) strike() {
+	for i := sb.struckFrom; i < len(sb.current.items); i++ {
+		sb.flowSpan(i).struck = true
+	}
+}
+
+// finishFlow leaves the current group with what layFlow needs to know of its spans, or with
+// nothing if richtext can lay them out.
+func (sb *spanBuilder) finishFlow() {
+	if sb.struck {
+		// The struck through text goes on in the next group.
+		sb.strike()
+		sb.struckFrom = 0
+	}
+	g := &sb.current
+	needed := false
+	for _, f := range g.flow {
+		needed = needed || f.struck || f.math != nil
+	}
+	if !needed {
+		g.flow = nil
+		return
+	}
+	sb.flowSpan(len(g.items) - 1)
+	// Spans that were copied from an interactive one (like bold text within a link) are
+	// interactive as well, with the same metadata.
+	for i := 1; i < len(g.items); i++ {
+		if g.flow[i].meta == nil && g.items[i].Interactive {
+			g.flow[i].meta = g.flow[i-1].meta
+		}
+	}
+}
+
+// flowPiece is the part of a span that's on one line.
+type flowPiece struct {
+	span    int
+	x       int
+	width   int
+	ascent  int
+	descent int
+	line    text.Line // the piece's text, unless it's math
+	math    *texBox
+}
+
+// layText lays out spans of text with richtext, or with layFlow if there's anything in them
+// that richtext can't show.
+func (d *Document) layText(gtx C, th *material.Theme, items []richtext.SpanStyle, flow []flowSpan, st *richtext.InteractiveText, fst *flowState) D {
+	if flow != nil {
+		return d.layFlow(gtx, th, items, flow, fst)
+	}
+	return richtext.Text(st, th.Shaper, items...).Layout(gtx)
+}
+
+// layFlow lays out spans of text the way that richtext does, wrapping each one at the words
+// that don't fit on what's left of the line, but draws the struck through ones with a line
+// through them and lays out inline math in the text's size (on a line of its own if it
+// doesn't fit on what's left of one). The interactive spans are clicked like the ones that
+// richtext lays out.
+func (d *Document) layFlow(gtx C, th *material.Theme, items []richtext.SpanStyle, flow []flowSpan, st *flowState) D {
+	if len(st.clicks) != len(items) {
+		st.clicks = make([]gesture.Click, len(items))
+	}
+	for i := range st.clicks {
+		for _, e := range st.clicks[i].Events(gtx) {
+			if e.Type == gesture.TypeClick {
+				meta := flow[i].meta
+				d.clickSpan(func(key string) interface{} { return meta[key] })
+			}
+		}
+	}
+
+	maxWidth := gtx.Constraints.Max.X
+	var lines [][]flowPiece
+	var line []flowPiece
+	x := 0
+	for i, s := range items {
+		if m := flow[i].math; m != nil {
+			l := texLayout{shaper: th.Shaper, color: s.Color}
+			box := l.lay(gtx, m, s.Size)
+			if x > 0 && box.width > maxWidth-x {
+				lines, line, x = append(lines, line), nil, 0
+			}
+			line = append(line, flowPiece{
+				span:    i,
+				x:       x,
+				width:   box.width,
+				ascent:  box.ascent,
+				descent: box.descent,
+				math:    &box,
+			})
+			x += box.width
+			continue
+		}
+		size := fixed.I(gtx.Sp(s.Size))
+		for content := s.Content; content != ""; {
+			shaped := th.Shaper.LayoutString(s.Font, size, maxWidth-x, gtx.Locale, content)
+			if len(shaped) == 0 {
+				break
+			}
+			l := shaped[0]
+			if x > 0 && l.Width.Ceil() > maxWidth-x {
+				// Not even the first word fits on what's left of the line.
+				lines, line, x = append(lines, line), nil, 0
+				continue
+			}
+			line = append(line, flowPiece{
+				span:    i,
+				x:       x,
+				width:   l.Width.Ceil(),
+				ascent:  l.Ascent.Ceil(),
+				descent: l.Descent.Ceil(),
+				line:    l,
+			})
+			x += l.Width.Ceil()
+			n := runeOffset(content, l.Layout.Runes.Count)
+			if n == 0 {
+				break
+			}
+			content = content[n:]
+			if len(shaped) > 1 {
+				lines, line, x = append(lines, line), nil, 0
+			}
+		}
+	}
+	if len(line) > 0 {
+		lines = append(lines, line)
+	}
+
+	var size image.Point
+	for _, line := range lines {
+		ascent, descent := 0, 0
+		for _, p := range line {
+			ascent = max(ascent, p.ascent)
+			descent = max(descent, p.descent)
+		}
+		for _, p := range line {
+			s := items[p.span]
+			w := p.width
+			stack := op.Offset(image.Point{p.x, size.Y + ascent}).Push(gtx.Ops)
+			if p.math != nil {
+				p.math.place(gtx.Ops, 0, -p.ascent)
+			} else {
+				paint.ColorOp{Color: s.Color}.Add(gtx.Ops)
+				path := th.Shaper.Shape(s.Font, fixed.I(gtx.Sp(s.Size)), p.line.Layout)
+				outline := clip.Outline{Path: path}.Op().Push(gtx.Ops)
+				paint.PaintOp{}.Add(gtx.Ops)
+				outline.Pop()
+			}
+			if flow[p.span].struck {
+				// The line goes through the middle of the lowercase letters.
+				thick := max(1, gtx.Dp(1))
+				y := -gtx.Sp(s.Size)*3/10 - thick/2
+				rect := clip.Rect{Min: image.Point{0, y}, Max: image.Point{w, y + thick}}
+				paint.FillShape(gtx.Ops, s.Color, rect.Op())
+			}
+			stack.Pop()
+			if s.Interactive {
+				area := clip.Rect{
+					Min: image.Point{p.x, size.Y},
+					Max: image.Point{p.x + w, size.Y + ascent + descent},
+				}.Push(gtx.Ops)
+				pointer.CursorPointer.Add(gtx.Ops)
+				st.clicks[p.span].Add(gtx.Ops)
+				area.Pop()
+			}
+			size.X = max(size.X, p.x+w)
+		}
+		size.Y += ascent + descent
+	}
+	return D{Size: size}
+}
+
+// runeOffset returns the offset of the rune at the given index of a string.
+func runeOffset(s string, runes int) int {
+	off := 0
+	for ; runes > 0 && off < len(s); runes-- {
+		_, n := utf8.DecodeRuneInString(s[off:])
+		off += n
+	}
+	return off
+}
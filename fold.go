@@ -0,0 +1,280 @@
+package mdedit
+
+import (
+	"bytes"
+	"sort"
+)
+
+// foldRange is a range of rows (inclusive) that can be folded, which hides all of its rows
+// behind a summary row (its first one).
+type foldRange struct {
+	start int
+	end   int
+}
+
+// foldRanges returns all of the ranges that can be folded, ordered by their first rows.
+// Those are heading sections (down to the next heading of the same or a higher level), list
+// items with nested content, and fenced code blocks. Any two ranges are either nested or
+// don't overlap at all.
+func (b *buffer) foldRanges() []foldRange {
+	type openHeading struct{ row, level int }
+	type openItem struct{ row, body int }
+	var (
+		ranges   []foldRange
+		headings []openHeading
+		items    []openItem
+		fence    []byte // the opening fence of the code block being scanned (if any)
+		fenceRow int
+		lastText int // the last row that isn't blank
+	)
+	add := func(start, end int) {
+		if end > start {
+			ranges = append(ranges, foldRange{start, end})
+		}
+	}
+	for row := range b.lines {
+		ln := &b.lines[row]
+		indent := ln.startingIndex()
+		if fence != nil {
+			if isClosingFence(ln.text[indent:], fence) {
+				add(fenceRow, row)
+				fence = nil
+				lastText = row
+			}
+			continue
+		}
+		if indent == len(ln.text) {
+			continue
+		}
+		// A line that is indented less than the content of a list item ends that item.
+		for n := len(items); n > 0 && indent < items[n-1].body; n-- {
+			add(items[n-1].row, lastText)
+			items = items[:n-1]
+		}
+		if f := openingFence(ln.text[indent:]); f != nil {
+			fence, fenceRow = f, row
+		} else if level := headingLevel(ln.text); level > 0 {
+			for n := len(headings); n > 0 && headings[n-1].level >= level; n-- {
+				add(headings[n-1].row, lastText)
+				headings = headings[:n-1]
+			}
+			headings = append(headings, openHeading{row, level})
+		} else if lp := parseListPrefix(ln.text); lp.isListItem() && !lp.quoted {
+			items = append(items, openItem{row, lp.body})
+		}
+		lastText = row
+	}
+	// Anything that's still open runs until the end of the buffer.
+	if fence != nil {
+		lastText = len(b.lines) - 1
+		add(fenceRow, lastText)
+	}
+	for i := len(items) - 1; i >= 0; i-- {
+		add(items[i].row, lastText)
+	}
+	for i := len(headings) - 1; i >= 0; i-- {
+		add(headings[i].row, lastText)
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].start < ranges[j].start
+	})
+	return ranges
+}
+
+// headingLevel returns the level of the ATX heading on the given line, or 0 if the line
+// isn't one.
+func headingLevel(text []byte) int {
+	i := 0
+	for i < len(text) && i < 3 && text[i] == ' ' {
+		i++
+	}
+	level := 0
+	for i+level < len(text) && text[i+level] == '#' {
+		level++
+	}
+	if level == 0 || level > 6 || (i+level < len(text) && !isSpace(text[i+level])) {
+		return 0
+	}
+	return level
+}
+
+// openingFence returns the fence (three or more backticks or tildes) that the given text
+// starts with, or nil if it doesn't start with one.
+func openingFence(text []byte) []byte {
+	if len(text) == 0 || (text[0] != '`' && text[0] != '~') {
+		return nil
+	}
+	n := 0
+	for n < len(text) && text[n] == text[0] {
+		n++
+	}
+	if n < 3 || (text[0] == '`' && bytes.IndexByte(text[n:], '`') != -1) {
+		return nil
+	}
+	return text[:n]
+}
+
+// isClosingFence reports whether the given text closes the code block that was opened with
+// the given fence.
+func isClosingFence(text, fence []byte) bool {
+	n := 0
+	for n < len(text) && text[n] == fence[0] {
+		n++
+	}
+	return n >= len(fence) && isSpace(text[n:]...)
+}
+
+// closedFolds returns the outermost closed folds (ordered by their first rows), which are
+// the ranges of rows that are currently hidden behind a summary row.
+func (b *buffer) closedFolds() (folds []foldRange) {
+	if len(b.folds) == 0 {
+		return nil
+	}
+	for _, r := range b.foldRanges() {
+		if b.folds[r.start] && (len(folds) == 0 || r.start > folds[len(folds)-1].end) {
+			folds = append(folds, r)
+		}
+	}
+	return folds
+}
+
+// closeFold closes the innermost fold that contains the given row and isn't closed yet.
+func (b *buffer) closeFold(row int) {
+	inner := -1
+	for _, r := range b.foldRanges() {
+		if r.start > row {
+			break
+		}
+		if r.end >= row && !b.folds[r.start] {
+			inner = r.start
+		}
+	}
+	if inner == -1 {
+		return
+	}
+	if b.folds == nil {
+		b.folds = make(map[int]bool)
+	}
+	b.folds[inner] = true
+}
+
+// foldAt returns the fold (out of the given ordered ones) that contains the given row.
+func foldAt(folds []foldRange, row int) (foldRange, bool) {
+	i := sort.Search(len(folds), func(i int) bool {
+		return folds[i].end >= row
+	})
+	if i < len(folds) && folds[i].start <= row {
+		return folds[i], true
+	}
+	return foldRange{}, false
+}
+
+// rowBelow returns the row that is displayed right below the given one, skipping over the
+// rest of a closed fold. It returns false if the given row is the last one displayed.
+func (b *buffer) rowBelow(folds []foldRange, row int) (int, bool) {
+	last := row
+	if f, ok := foldAt(folds, row); ok {
+		last = f.end
+	}
+	if last >= len(b.lines)-1 {
+		return row, false
+	}
+	return last + 1, true
+}
+
+// rowAbove returns the row that is displayed right above the given one, which is the first
+// row of a closed fold if the row above is hidden inside of one. It returns false if the
+// given row is the first one displayed.
+func (b *buffer) rowAbove(folds []foldRange, row int) (int, bool) {
+	if f, ok := foldAt(folds, row); ok {
+		row = f.start
+	}
+	if row == 0 {
+		return row, false
+	}
+	row--
+	if f, ok := foldAt(folds, row); ok {
+		row = f.start
+	}
+	return row, true
+}
+
+// zExec executes the fold commands: `za` toggles, `zc` closes and `zo` opens the fold at
+// the cursor, while `zM` closes and `zR` opens all folds.
+func (ed *Editor) zExec(c *command) {
+	row := ed.buf.cursor.row
+	switch c.cmdChar {
+	case 'a':
+		if f, ok := foldAt(ed.buf.closedFolds(), row); ok {
+			delete(ed.buf.folds, f.start)
+		} else {
+			ed.buf.closeFold(row)
+		}
+	case 'c':
+		ed.buf.closeFold(row)
+	case 'o':
+		if f, ok := foldAt(ed.buf.closedFolds(), row); ok {
+			delete(ed.buf.folds, f.start)
+		}
+	case 'M':
+		ed.buf.folds = make(map[int]bool)
+		for _, r := range ed.buf.foldRanges() {
+			ed.buf.folds[r.start] = true
+		}
+	case 'R':
+		ed.buf.folds = nil
+	}
+	ed.keepCursorOutOfFolds()
+}
+
+// updateFolds keeps the closed folds (and the signs in the gutter) on the same lines after
+// lines were added or removed below the given row (the cursor's row from before the change),
+// and forgets about any folds whose first lines can't be folded anymore.
+func (ed *Editor) updateFolds(numLines, row int) {
+	delta := len(ed.buf.lines) - numLines
+	if delta != 0 {
+		ed.shiftSigns(row, delta)
+	}
+	if len(ed.buf.folds) == 0 {
+		return
+	}
+	if delta != 0 {
+		folds := make(map[int]bool, len(ed.buf.folds))
+		for start := range ed.buf.folds {
+			switch {
+			case start <= row:
+				folds[start] = true
+			case start+delta > row:
+				folds[start+delta] = true
+			}
+		}
+		ed.buf.folds = folds
+	}
+	folds := make(map[int]bool, len(ed.buf.folds))
+	for _, r := range ed.buf.foldRanges() {
+		if ed.buf.folds[r.start] {
+			folds[r.start] = true
+		}
+	}
+	ed.buf.folds = folds
+	ed.keepCursorOutOfFolds()
+}
+
+// keepCursorOutOfFolds makes sure the cursor isn't hidden inside of a closed fold. In
+// insert mode the fold gets opened (since its text is being edited), otherwise the cursor
+// moves up to the fold's summary row.
+func (ed *Editor) keepCursorOutOfFolds() {
+	for {
+		f, ok := foldAt(ed.buf.closedFolds(), ed.buf.cursor.row)
+		if !ok || f.start == ed.buf.cursor.row {
+			break
+		}
+		if ed.mode != modeInsert {
+			ed.buf.cursor.row = f.start
+			ed.buf.clampCol(true)
+			break
+		}
+		delete(ed.buf.folds, f.start)
+	}
+	ed.buf.mvViewIntoCursor()
+}
@@ -0,0 +1,107 @@
+package mdedit
+
+import (
+	"fmt"
+	"strings"
+
+	"gioui.org/text"
+	"gioui.org/widget/material"
+	"gioui.org/x/richtext"
+	"github.com/yuin/goldmark/ast"
+	extast "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/util"
+)
+
+// isFootnote is a footnote at the end of the document, which references scroll to.
+type isFootnote struct {
+	id string
+}
+
+func footnoteID(index int) string {
+	return fmt.Sprintf("fn:%d", index)
+}
+
+// renderFootnoteLink renders a footnote reference as a small number that links to the
+// footnote.
+func (sb *spanBuilder) renderFootnoteLink(_ util.BufWriter, _ []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*extast.FootnoteLink)
+	style := *sb.currentSpan()
+	sb.current.items = append(sb.current.items, richtext.SpanStyle{
+		Font:        style.Font,
+		Size:        style.Size * 0.7,
+		Color:       sb.theme.ContrastFg,
+		Content:     fmt.Sprint(n.Index),
+		Interactive: true,
+	})
+	sb.setMeta(len(sb.current.items)-1, linkDestKey, "#"+footnoteID(n.Index))
+	style.Content = ""
+	sb.current.items = append(sb.current.items, style)
+	return ast.WalkContinue, nil
+}
+
+func (sb *spanBuilder) renderFootnoteBacklink(_ util.BufWriter, _ []byte, _ ast.Node, _ bool) (ast.WalkStatus, error) {
+	return ast.WalkContinue, nil
+}
+
+// renderFootnoteList separates the footnotes from the rest of the document with a rule.
+func (sb *spanBuilder) renderFootnoteList(_ util.BufWriter, _ []byte, _ ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		sb.current = spanGroup{mdata: isHr{}}
+		sb.commitGroup()
+	}
+	return ast.WalkContinue, nil
+}
+
+func (sb *spanBuilder) renderFootnote(_ util.BufWriter, _ []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		n := node.(*extast.Footnote)
+		sb.current = spanGroup{mdata: isFootnote{id: footnoteID(n.Index)}}
+		sb.newSpan(material.Body2(sb.theme, ""))
+		sb.currentSpan().Content = fmt.Sprintf("%d.  ", n.Index)
+	} else if sb.hasContent() {
+		sb.commitGroup()
+	}
+	return ast.WalkContinue, nil
+}
+
+// renderDefinitionList renders a definition list as a single group.
+func (sb *spanBuilder) renderDefinitionList(_ util.BufWriter, _ []byte, _ ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		sb.definitions++
+		return ast.WalkContinue, nil
+	}
+	if sb.definitions--; sb.definitions == 0 {
+		for i := len(sb.current.items) - 1; i >= 0; i-- {
+			if c := &sb.current.items[i].Content; *c != "" {
+				*c = strings.TrimSuffix(*c, "\n")
+				break
+			}
+		}
+		sb.commitGroup()
+	}
+	return ast.WalkContinue, nil
+}
+
+func (sb *spanBuilder) renderDefinitionTerm(_ util.BufWriter, _ []byte, _ ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		l := material.Body1(sb.theme, "")
+		l.Font.Weight = text.Bold
+		sb.newSpan(l)
+	} else {
+		sb.endLine()
+	}
+	return ast.WalkContinue, nil
+}
+
+func (sb *spanBuilder) renderDefinitionDescription(_ util.BufWriter, _ []byte, _ ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		sb.newSpan(material.Body1(sb.theme, ""))
+		sb.currentSpan().Content = "        "
+	} else {
+		sb.endLine()
+	}
+	return ast.WalkContinue, nil
+}
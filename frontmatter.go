@@ -0,0 +1,174 @@
+package mdedit
+
+import (
+	"bytes"
+	"strings"
+
+	"gioui.org/text"
+	"gioui.org/widget/material"
+)
+
+// frontMatter is the metadata (from YAML or TOML front matter) that's shown at the top of a
+// document.
+type frontMatter struct {
+	title string
+	date  string
+	tags  []string
+}
+
+// frontMatterDelim returns the character of the front matter delimiter (`---` for YAML or
+// `+++` for TOML) that the given line consists of, or 0 if it isn't one.
+func frontMatterDelim(line []byte) byte {
+	line = bytes.TrimRight(line, " \t\r")
+	switch {
+	case bytes.Equal(line, []byte("---")):
+		return '-'
+	case bytes.Equal(line, []byte("+++")):
+		return '+'
+	}
+	return 0
+}
+
+// frontMatterLang returns the language that front matter with the given delimiter is in.
+func frontMatterLang(delim byte) *codeLang {
+	if delim == '+' {
+		return langTOML
+	}
+	return langYAML
+}
+
+// closedFrontMatter returns the delimiter character of the front matter that the given
+// lines start with, or 0 if they don't (as it's only front matter once a line closes it).
+func closedFrontMatter(lines []line) byte {
+	if len(lines) == 0 {
+		return 0
+	}
+	delim := frontMatterDelim(lines[0].text)
+	if delim == 0 {
+		return 0
+	}
+	for _, ln := range lines[1:] {
+		if frontMatterDelim(ln.text) == delim {
+			return delim
+		}
+	}
+	return 0
+}
+
+// splitFrontMatter returns the length of the front matter at the start of the given source
+// (including both of its delimiter lines) along with its delimiter, or 0 if there isn't any.
+func splitFrontMatter(src []byte) (int, byte) {
+	first, rest, ok := bytes.Cut(src, []byte{'\n'})
+	delim := frontMatterDelim(first)
+	if !ok || delim == 0 {
+		return 0, 0
+	}
+	n := len(first) + 1
+	for len(rest) > 0 {
+		var ln []byte
+		ln, rest, ok = bytes.Cut(rest, []byte{'\n'})
+		n += len(ln)
+		if ok {
+			n++
+		}
+		if frontMatterDelim(ln) == delim {
+			return n, delim
+		}
+	}
+	return 0, 0
+}
+
+// parseFrontMatter picks out the title, date and tags of the given front matter. Only simple
+// `key: value` (or, for TOML, `key = value`) pairs are understood, where the tags can be a
+// `[flow, list]`, a comma separated string or (in YAML) a block sequence of `- items`.
+func parseFrontMatter(data []byte, delim byte) (fm frontMatter) {
+	sep := ":"
+	if delim == '+' {
+		sep = "="
+	}
+	var key string
+	for _, ln := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimSpace(ln)
+		if strings.HasPrefix(trimmed, "- ") && key == "tags" {
+			fm.tags = append(fm.tags, unquote(trimmed[2:]))
+			continue
+		}
+		// Nested values aren't needed.
+		if trimmed == "" || ln[0] == ' ' || ln[0] == '\t' || frontMatterDelim([]byte(ln)) != 0 {
+			continue
+		}
+		k, v, ok := strings.Cut(trimmed, sep)
+		if !ok {
+			key = ""
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(k))
+		v = strings.TrimSpace(v)
+		switch key {
+		case "title":
+			fm.title = unquote(v)
+		case "date":
+			fm.date = unquote(v)
+		case "tags":
+			v = strings.TrimSuffix(strings.TrimPrefix(v, "["), "]")
+			for _, t := range strings.Split(v, ",") {
+				if t = unquote(strings.TrimSpace(t)); t != "" {
+					fm.tags = append(fm.tags, t)
+				}
+			}
+		}
+	}
+	return fm
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// blankFrontMatter returns a copy of the source where the first n bytes (the front matter)
+// are replaced with spaces. The line breaks are kept, which keeps every line where it was.
+func blankFrontMatter(src []byte, n int) []byte {
+	out := append([]byte{}, src...)
+	for i := 0; i < n; i++ {
+		if out[i] != '\n' {
+			out[i] = ' '
+		}
+	}
+	return out
+}
+
+// addFrontMatterCard adds a group with the front matter's title, date and tags.
+func (sb *spanBuilder) addFrontMatterCard(fm frontMatter) {
+	if fm.title == "" && fm.date == "" && len(fm.tags) == 0 {
+		return
+	}
+	sb.current.mdata = isFrontMatter{}
+	if fm.title != "" {
+		l := material.H5(sb.theme, "")
+		l.Font.Weight = text.Bold
+		sb.newSpan(l)
+		sb.currentSpan().Content = fm.title
+	}
+	if fm.date != "" || len(fm.tags) != 0 {
+		if fm.title != "" {
+			sb.currentSpan().Content += "\n"
+		}
+		l := material.Body2(sb.theme, "")
+		l.Color.A = 150
+		sb.newSpan(l)
+		sb.currentSpan().Content = fm.date
+	}
+	for i, t := range fm.tags {
+		if i != 0 || fm.date != "" {
+			sb.currentSpan().Content += "   "
+		}
+		l := material.Body2(sb.theme, "")
+		l.Color = codeTokenColors[tokenKeyword]
+		sb.newSpan(l)
+		sb.currentSpan().Content = "#" + t
+	}
+	sb.commitGroup()
+}
@@ -0,0 +1,128 @@
+package mdedit
+
+import (
+	"image"
+	"image/color"
+	"strconv"
+
+	"gioui.org/op"
+	"gioui.org/op/paint"
+	"golang.org/x/image/math/fixed"
+)
+
+// LineNumbers is how the editor displays line numbers.
+type LineNumbers uint8
+
+const (
+	// LineNumbersHybrid shows the absolute number of the cursor's line and the distance
+	// from the cursor for all other lines.
+	LineNumbersHybrid LineNumbers = iota
+	LineNumbersAbsolute
+	LineNumbersRelative
+	LineNumbersNone
+)
+
+// SignColumn identifies one of the columns in the editor's gutter that show signs next to
+// lines. A sign column only takes up space while it has any signs in it.
+type SignColumn uint8
+
+const (
+	SignColumnDiagnostics SignColumn = iota
+	SignColumnChanges
+	SignColumnSearch
+	numSignColumns
+)
+
+// Sign is a short marker (one or two characters) shown in a sign column.
+type Sign struct {
+	Text  string
+	Color color.NRGBA
+}
+
+// signColWidth is the width (in characters) of each sign column.
+const signColWidth = 2
+
+// SetSign puts the given sign in the given column next to the given row, replacing any
+// sign that was already there.
+func (ed *Editor) SetSign(c SignColumn, row int, s Sign) {
+	if ed.signs[c] == nil {
+		ed.signs[c] = make(map[int]Sign)
+	}
+	ed.signs[c][row] = s
+}
+
+// ClearSigns removes all of the signs in the given column.
+func (ed *Editor) ClearSigns(c SignColumn) {
+	ed.signs[c] = nil
+}
+
+// shiftSigns moves the signs below the given row by the given number of rows after lines
+// were added or removed there. The signs of removed lines are dropped.
+func (ed *Editor) shiftSigns(row, delta int) {
+	for c, signs := range ed.signs {
+		if len(signs) == 0 {
+			continue
+		}
+		shifted := make(map[int]Sign, len(signs))
+		for r, s := range signs {
+			switch {
+			case r <= row:
+				shifted[r] = s
+			case r+delta > row:
+				shifted[r+delta] = s
+			}
+		}
+		ed.signs[c] = shifted
+	}
+}
+
+// cycleLineNumbers switches to the next way of displaying line numbers.
+func (ed *Editor) cycleLineNumbers() {
+	ed.LineNumbers = (ed.LineNumbers + 1) % (LineNumbersNone + 1)
+}
+
+// lineNumberWidth returns the width that is needed to display the line numbers.
+func (ed *Editor) lineNumberWidth() int {
+	if ed.LineNumbers == LineNumbersNone {
+		return 0
+	}
+	return ed.charWidth * max(2, len(strconv.Itoa(len(ed.buf.lines))))
+}
+
+// gutterWidth returns the width of the sign columns and line numbers in front of the text.
+func (ed *Editor) gutterWidth() (w int) {
+	for c := range ed.signs {
+		if len(ed.signs[c]) != 0 {
+			w += signColWidth * ed.charWidth
+		}
+	}
+	if ed.LineNumbers != LineNumbersNone {
+		w += ed.lnNumSpace + ed.charWidth
+	}
+	return w
+}
+
+// drawGutter draws any signs and the line number for the given row, and returns the width
+// of the gutter (which is where the line's text starts).
+func (ed *Editor) drawGutter(gtx C, size fixed.Int26_6, row int) int {
+	x := 0
+	for c := range ed.signs {
+		if len(ed.signs[c]) == 0 {
+			continue
+		}
+		if s, ok := ed.signs[c][row]; ok {
+			xOffsetOp := op.Offset(image.Point{X: x}).Push(gtx.Ops)
+			paint.ColorOp{Color: s.Color}.Add(gtx.Ops)
+			drawText(gtx, ed.shaper, ed.font, size, s.Text)
+			xOffsetOp.Pop()
+		}
+		x += signColWidth * ed.charWidth
+	}
+	if ed.LineNumbers != LineNumbersNone {
+		xOffsetOp := op.Offset(image.Point{X: x}).Push(gtx.Ops)
+		ed.drawLineNumber(gtx, size, row)
+		xOffsetOp.Pop()
+		x += ed.lnNumSpace + ed.charWidth
+	}
+	return x
+}
@@ -4,7 +4,7 @@ import "bytes"
 
 const (
 	// blocks
-	mdHeading uint16 = 1 << iota
+	mdHeading uint32 = 1 << iota
 	mdBlockquote
 	mdCodeBlock
 	mdThematicBreak
@@ -13,211 +13,601 @@ const (
 	mdStrong
 	mdCodeSpan
 	mdListMarker
+	mdLinkText
 	mdLinkURL
+	mdStrikethrough
+	mdTask
+	mdTaskDone
+	mdFootnote
+	mdFrontMatter
+	// gfm blocks
+	mdTable
+	mdSetextHeading
+	// math
+	mdMath
+	// code block tokens
+	mdCodeKeyword
+	mdCodeString
+	mdCodeComment
+	mdCodeNumber
 )
 
+// tokenMarks are the style marks for each kind of token in a fenced code block.
+var tokenMarks = [...]uint32{
+	tokenKeyword: mdCodeKeyword,
+	tokenString:  mdCodeString,
+	tokenComment: mdCodeComment,
+	tokenNumber:  mdCodeNumber,
+}
+
 type mdStyleMark struct {
 	col   int
-	value uint16
+	value uint32
 }
 
-type styleBuilder struct {
-	markers [][]mdStyleMark
-	row     int
+// hlState is everything the highlighter carries over from one line to the next, which is
+// all it needs in order to pick up highlighting at the start of any line.
+type hlState struct {
+	marks       uint32
+	bqState     uint8
+	inCodeSpan  uint8
+	inEmphasis1 byte
+	inEmphasis2 byte
+	// codeBlock is one more than the column of the opening fence while inside of a fenced
+	// code block (and zero otherwise).
+	codeBlock int
+	codeLang  *codeLang // the language of the fenced code block (if it names one)
+	code      tokState
+	table     uint8
+	// setext is whether the line is the underline of a setext heading.
+	setext bool
+	// frontMatter is the delimiter character while inside of front matter.
+	frontMatter byte
+	// frontMatterStart is the delimiter character of the front matter that the line starts,
+	// which only the first line can (and only if a later line closes it).
+	frontMatterStart byte
 }
 
-func (s *styleBuilder) startNewRow() {
-	if s.row == len(s.markers) {
-		s.markers = append(s.markers, []mdStyleMark{})
-	}
-	s.row++
+const (
+	bqStarted uint8 = iota + 1
+	bqHitChar
+)
+
+const (
+	codeSpan1 uint8 = iota + 1
+	codeSpan2
+)
+
+const (
+	tableHeader uint8 = iota + 1
+	tableBody
+)
+
+// mdHighlighter caches the lines it last highlighted along with the state at the start of
+// each of them. That way only the lines from the first one that changed onward have to be
+// highlighted again, and only until the state converges with what it was before.
+type mdHighlighter struct {
+	lines  [][]byte
+	states []hlState // one more than there are lines (the state after the last line)
+	marks  [][]mdStyleMark
 }
 
-func (s *styleBuilder) add(v uint16, col int) {
-	s.markers[s.row] = append(s.markers[s.row], mdStyleMark{
-		col:   col,
-		value: v,
-	})
+func (h *mdHighlighter) highlight(buf *buffer) [][]mdStyleMark {
+	n, prevN := len(buf.lines), len(h.lines)
+	// Find the unchanged lines at the start and at the end of the buffer. The lines in
+	// between are the ones that were edited (or added).
+	pre := 0
+	for pre < min(n, prevN) && bytes.Equal(buf.lines[pre].text, h.lines[pre]) {
+		pre++
+	}
+	if pre == n && n == prevN {
+		return h.marks
+	}
+	suf := 0
+	for suf < min(n, prevN)-pre && bytes.Equal(buf.lines[n-1-suf].text, h.lines[prevN-1-suf]) {
+		suf++
+	}
+	shift := prevN - n // what to add to a row in the unchanged end to get its previous row
+	lines, states, marks := h.lines, h.states, h.marks
+	if shift != 0 {
+		lines = make([][]byte, n)
+		states = make([]hlState, n+1)
+		marks = make([][]mdStyleMark, n)
+		copy(lines, h.lines[:pre])
+		copy(states, h.states[:pre])
+		copy(marks, h.marks[:pre])
+	}
+
+	// Lines are highlighted depending on the line after them as well (such as the text of
+	// a setext heading), so the line before the first changed one might change too.
+	if pre > 0 {
+		pre--
+	}
+	// Whether the first line starts front matter depends on whether a later line closes it.
+	fm := closedFrontMatter(buf.lines)
+	if len(h.states) == 0 || h.states[0].frontMatterStart != fm {
+		pre = 0
+	}
+	st := hlState{frontMatterStart: fm}
+	if pre > 0 {
+		st = h.states[pre]
+	}
+	row := pre
+	for ; row < n; row++ {
+		// Once an unchanged line starts out with the same state as before, every line
+		// from there on will be highlighted the same as before.
+		if row >= n-suf && st == h.states[row+shift] {
+			break
+		}
+		lines[row] = append([]byte{}, buf.lines[row].text...)
+		states[row] = st
+		var next []byte
+		if row+1 < n {
+			next = buf.lines[row+1].text
+		}
+		marks[row] = highlightLine(&st, buf.lines[row].text, next)
+	}
+	if row < n && shift != 0 {
+		copy(lines[row:], h.lines[row+shift:])
+		copy(states[row:], h.states[row+shift:])
+		copy(marks[row:], h.marks[row+shift:])
+	} else if row == n {
+		states[n] = st
+	}
+	h.lines, h.states, h.marks = lines, states, marks
+	return marks
 }
 
-type mdHighlighter struct{}
-
-func (mdHighlighter) highlight(buf *buffer) [][]mdStyleMark {
-	const (
-		bqStarted uint8 = iota + 1
-		bqHitChar
-		codeSpan1 uint8 = iota + 1
-		codeSpan2
-	)
-	var (
-		marks        uint16
-		maybeHeading bool
-		bqState      uint8
-		inCodeSpan   uint8
-		inEmphasis1  byte
-		inEmphasis2  byte
-	)
-	sb := styleBuilder{markers: make([][]mdStyleMark, len(buf.lines))}
-
-lineloop:
-	for row := 0; row < len(buf.lines); row++ {
-		line := buf.lines[row].text
-		if len(line) == 0 {
-			if marks&mdBlockquote == mdBlockquote && bqState == bqHitChar {
-				marks = marks &^ mdBlockquote
-				bqState = 0
-			}
-		}
-		marks = marks &^ mdHeading
-		sb.add(marks, 0)
-
-		var start int
-		for start = 0; start < len(line); start++ {
-			if line[start] != ' ' && line[start] != '\t' {
-				break
+// highlightLine returns the style marks for a line of text that starts out with the given
+// state, which is then updated to what the next line starts out with. The text of the next
+// line is only looked at to tell whether this line is a setext heading or a table header.
+func highlightLine(st *hlState, line, next []byte) (rowMarks []mdStyleMark) {
+	add := func(v uint32, col int) {
+		rowMarks = append(rowMarks, mdStyleMark{col: col, value: v})
+	}
+	var start int
+	for start = 0; start < len(line); start++ {
+		if line[start] != ' ' && line[start] != '\t' {
+			break
+		}
+	}
+	if st.frontMatterStart != 0 {
+		st.frontMatter, st.frontMatterStart = st.frontMatterStart, 0
+		add(mdFrontMatter, 0)
+		return rowMarks
+	}
+	if st.frontMatter != 0 {
+		add(mdFrontMatter, 0)
+		if frontMatterDelim(line) == st.frontMatter {
+			st.frontMatter = 0
+			st.code = tokState{}
+			return rowMarks
+		}
+		for _, tok := range frontMatterLang(st.frontMatter).tokenize(line, &st.code) {
+			add(mdFrontMatter|tokenMarks[tok.kind], tok.start)
+			add(mdFrontMatter, tok.end)
+		}
+		return rowMarks
+	}
+	if st.codeBlock != 0 {
+		base := st.marks | mdCodeBlock
+		add(base, st.codeBlock-1)
+		if bytes.Equal(line[start:], []byte("```")) {
+			st.codeBlock = 0
+			st.codeLang = nil
+			st.code = tokState{}
+			return rowMarks
+		}
+		if st.codeLang != nil {
+			for _, tok := range st.codeLang.tokenize(line, &st.code) {
+				if tok.start >= st.codeBlock-1 {
+					add(base|tokenMarks[tok.kind], tok.start)
+					add(base, tok.end)
+				}
 			}
 		}
+		return rowMarks
+	}
 
-		for col := start; col < len(line); col++ {
-			char := line[col]
+	marks := st.marks
+	bqState := st.bqState
+	inCodeSpan := st.inCodeSpan
+	inEmphasis1 := st.inEmphasis1
+	inEmphasis2 := st.inEmphasis2
+	maybeHeading := false
+	defer func() {
+		st.marks = marks
+		st.bqState = bqState
+		st.inCodeSpan = inCodeSpan
+		st.inEmphasis1 = inEmphasis1
+		st.inEmphasis2 = inEmphasis2
+	}()
 
-			if col == start {
-				switch char {
-				case '#':
-					maybeHeading = true
-				case '>':
-					marks |= mdBlockquote
-					sb.add(marks, col)
-					bqState = bqStarted
-				case '*', '+', '-':
-					if col+1 < len(line)-1 && line[col+1] == ' ' {
-						sb.add(marks|mdListMarker, col)
-						sb.add(marks, col+1)
-						col++
-					}
-				case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
-					if col+2 < len(line)-1 && line[col+1] == '.' && line[col+2] == ' ' {
-						sb.add(marks|mdListMarker, col)
-						sb.add(marks, col+2)
-						col += 2
-					}
+	if len(line) == 0 {
+		if marks&mdBlockquote == mdBlockquote && bqState == bqHitChar {
+			marks = marks &^ mdBlockquote
+			bqState = 0
+		}
+	}
+	marks = marks &^ (mdHeading | mdTaskDone)
+	if st.setext {
+		st.setext = false
+		add(marks|mdSetextHeading, 0)
+		return rowMarks
+	}
+	switch {
+	case start == len(line), st.table == tableBody && bytes.IndexByte(line, '|') == -1:
+		st.table = 0
+	case st.table == tableHeader:
+		if isTableDelimRow(line) {
+			st.table = tableBody
+			add(marks|mdTable, 0)
+			return rowMarks
+		}
+		st.table = 0
+	}
+	lp := parseListPrefix(line)
+	if st.table == 0 {
+		switch {
+		case bytes.IndexByte(line, '|') != -1 && isTableDelimRow(next):
+			st.table = tableHeader
+		case isSetextUnderline(next) && start < 4 && start < len(line) && !lp.continues() &&
+			headingLevel(line) == 0 && openingFence(line[start:]) == nil:
+			marks |= mdHeading
+			st.setext = true
+		}
+	}
+	add(marks, 0)
+	boxCol := -1
+	if lp.task {
+		boxCol = lp.body
+	}
+
+	for col := start; col < len(line); col++ {
+		char := line[col]
+
+		if col == start {
+			switch char {
+			case '#':
+				maybeHeading = true
+			case '>':
+				marks |= mdBlockquote
+				add(marks, col)
+				bqState = bqStarted
+			case '*', '+', '-':
+				if col+1 < len(line)-1 && line[col+1] == ' ' {
+					add(marks|mdListMarker, col)
+					add(marks, col+1)
+					col++
+				}
+			case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+				if col+2 < len(line)-1 && line[col+1] == '.' && line[col+2] == ' ' {
+					add(marks|mdListMarker, col)
+					add(marks, col+2)
+					col += 2
 				}
 			}
-			if bqState == bqStarted && char != ' ' && char != '\t' && char != '>' {
-				bqState = bqHitChar
+		}
+		if bqState == bqStarted && char != ' ' && char != '\t' && char != '>' {
+			bqState = bqHitChar
+		}
+		if maybeHeading && char != '#' {
+			if char == ' ' {
+				marks |= mdHeading
+				add(marks, start)
 			}
-			if maybeHeading && char != '#' {
-				if char == ' ' {
-					marks |= mdHeading
-					sb.add(marks, start)
-				}
-				maybeHeading = false
+			maybeHeading = false
+		}
+		if col == boxCol {
+			box := marks | mdTask
+			if line[col+1] != ' ' {
+				box |= mdTaskDone
+				marks |= mdTaskDone
+			}
+			add(box, col)
+			add(marks, col+3)
+			col += 2
+			continue
+		}
+		if inCodeSpan == 0 {
+			if end := highlightFootnote(line, col, col == start, marks, add); end != -1 {
+				col = end - 1
+				continue
+			}
+			if end := highlightLink(line, col, col == start, marks, add); end != -1 {
+				col = end - 1
+				continue
+			}
+		}
+		switch char {
+		case '*', '_':
+			var prev, next byte
+			if col > 0 {
+				prev = line[col-1]
+			}
+			if col+1 < len(line) {
+				next = line[col+1]
 			}
-			switch char {
-			case '*', '_':
-				var prev, next byte
-				if col > 0 {
-					prev = line[col-1]
-				}
-				if col+1 < len(line) {
-					next = line[col+1]
-				}
 
-				isPrevBlank := (prev == 0 || prev == ' ' || prev == '\t')
-				isNextBlank := (next == 0 || next == ' ' || next == '\t')
-
-				if char == next {
-					switch {
-					case inEmphasis2 == 0 && isPrevBlank:
-						var next2 byte
-						if col+2 < len(line) {
-							next2 = line[col+2]
-						}
-						if next2 != ' ' && next2 != '\t' {
-							inEmphasis2 = char
-							marks |= mdStrong
-							sb.add(marks, col)
-							col++
-						}
-					case inEmphasis2 == char && !isPrevBlank:
-						inEmphasis2 = 0
-						marks &^= mdStrong
-						sb.add(marks, col+2)
+			isPrevBlank := (prev == 0 || prev == ' ' || prev == '\t')
+			isNextBlank := (next == 0 || next == ' ' || next == '\t')
+
+			if char == next {
+				switch {
+				case inEmphasis2 == 0 && isPrevBlank:
+					var next2 byte
+					if col+2 < len(line) {
+						next2 = line[col+2]
+					}
+					if next2 != ' ' && next2 != '\t' {
+						inEmphasis2 = char
+						marks |= mdStrong
+						add(marks, col)
 						col++
 					}
-				} else {
-					switch {
-					case inEmphasis1 == 0 && isPrevBlank && !isNextBlank:
-						inEmphasis1 = char
-						marks |= mdItalic
-						sb.add(marks, col)
-					case inEmphasis1 != 0 && !isPrevBlank && (isNextBlank || isPunct(next) || next == inEmphasis2):
-						inEmphasis1 = 0
-						marks &^= mdItalic
-						if col < len(line)-1 {
-							sb.add(marks, col+1)
-						}
+				case inEmphasis2 == char && !isPrevBlank:
+					inEmphasis2 = 0
+					marks &^= mdStrong
+					add(marks, col+2)
+					col++
+				}
+			} else {
+				switch {
+				case inEmphasis1 == 0 && isPrevBlank && !isNextBlank:
+					inEmphasis1 = char
+					marks |= mdItalic
+					add(marks, col)
+				case inEmphasis1 != 0 && !isPrevBlank && (isNextBlank || isPunct(next) || next == inEmphasis2):
+					inEmphasis1 = 0
+					marks &^= mdItalic
+					if col < len(line)-1 {
+						add(marks, col+1)
 					}
 				}
-			case '`':
-				var next byte
-				if col+1 < len(line) {
-					next = line[col+1]
+			}
+		case '~':
+			// Only a pair of tildes is strikethrough (three or more start a fence).
+			if col+1 == len(line) || line[col+1] != '~' || (col > 0 && line[col-1] == '~') || (col+2 < len(line) && line[col+2] == '~') {
+				break
+			}
+			switch {
+			case marks&mdStrikethrough == 0 && col+2 < len(line) && !isSpace(line[col+2]):
+				marks |= mdStrikethrough
+				add(marks, col)
+				col++
+			case marks&mdStrikethrough != 0 && col > 0 && !isSpace(line[col-1]):
+				marks &^= mdStrikethrough
+				add(marks, col+2)
+				col++
+			}
+		case '|':
+			if st.table != 0 && inCodeSpan == 0 && (col == 0 || line[col-1] != '\\') {
+				add(marks|mdTable, col)
+				add(marks, col+1)
+			}
+		case '`':
+			var next byte
+			if col+1 < len(line) {
+				next = line[col+1]
+			}
+			if col == start && next == '`' && col+2 < len(line) && line[col+2] == '`' {
+				// The fenced code block's lines are all marked from the fence's column on
+				// until the closing fence.
+				add(marks|mdCodeBlock, start)
+				st.codeBlock = start + 1
+				st.codeLang = codeLangFor(bytes.TrimLeft(line[start:], "`"))
+				return rowMarks
+			}
+			switch inCodeSpan {
+			case 0:
+				inCodeSpan = codeSpan1
+				marks |= mdCodeSpan
+				add(marks, col)
+				if next == '`' {
+					inCodeSpan = codeSpan2
+					col += 2
 				}
-				if col == start && next == '`' && col+2 < len(line) && line[col+2] == '`' {
-					sb.add(marks|mdCodeBlock, start)
-					delimCodeBlock := []byte("```")
-					for {
-						row++
-						if row >= len(buf.lines) {
-							break lineloop
-						}
-						sb.startNewRow()
-						sb.add(marks|mdCodeBlock, start)
-						ln := &buf.lines[row]
-						if bytes.Equal(ln.text[ln.startingIndex():], delimCodeBlock) {
-							break
-						}
-					}
-					sb.startNewRow()
-					sb.add(marks, 0)
-					continue lineloop
+			case codeSpan1:
+				marks &^= mdCodeSpan
+				inCodeSpan = 0
+				if col < len(line)-1 {
+					add(marks, col+1)
 				}
-				switch inCodeSpan {
-				case 0:
-					inCodeSpan = codeSpan1
-					marks |= mdCodeSpan
-					sb.add(marks, col)
-					if next == '`' {
-						inCodeSpan = codeSpan2
-						col += 2
-					}
-				case codeSpan1:
+			case codeSpan2:
+				if next == '`' {
 					marks &^= mdCodeSpan
 					inCodeSpan = 0
-					if col < len(line)-1 {
-						sb.add(marks, col+1)
-					}
-				case codeSpan2:
-					if next == '`' {
-						marks &^= mdCodeSpan
-						inCodeSpan = 0
-						col++
-						if col+1 < len(line)-1 {
-							sb.add(marks, col+2)
-						}
+					col++
+					if col+1 < len(line)-1 {
+						add(marks, col+2)
 					}
 				}
 			}
 		}
-		if maybeHeading {
-			sb.add(marks|mdHeading, start)
-			maybeHeading = false
+	}
+	if maybeHeading {
+		add(marks|mdHeading, start)
+	}
+	return rowMarks
+}
+
+// highlightFootnote adds the marks for a footnote reference (or, if atStart is true, a
+// footnote definition's label) that starts at the given column. It returns the column right
+// after it, or -1 if there isn't one there.
+func highlightFootnote(line []byte, col int, atStart bool, marks uint32, add func(uint32, int)) int {
+	if line[col] != '[' || col+2 >= len(line) || line[col+1] != '^' {
+		return -1
+	}
+	end := closingEnd(line, col, '[', ']')
+	if end == -1 || end == col+3 && line[col+2] == ']' {
+		return -1
+	}
+	if atStart && end < len(line) && line[end] == ':' {
+		end++
+	}
+	add(marks|mdFootnote, col)
+	add(marks, end)
+	return end
+}
+
+// highlightLink adds the marks for any link, image, autolink, bare URL or (if atStart is
+// true) link reference definition that starts at the given column. It returns the column
+// right after it, or -1 if there isn't one there.
+func highlightLink(line []byte, col int, atStart bool, marks uint32, add func(uint32, int)) int {
+	switch line[col] {
+	case '!':
+		if col+1 == len(line) || line[col+1] != '[' {
+			return -1
+		}
+		textEnd, urlEnd := linkEnds(line, col+1)
+		if urlEnd == -1 {
+			return -1
+		}
+		add(marks|mdLinkText|mdItalic, col)
+		add(marks|mdLinkURL, textEnd)
+		add(marks, urlEnd)
+		return urlEnd
+	case '[':
+		textEnd, urlEnd := linkEnds(line, col)
+		switch {
+		case urlEnd != -1:
+		case atStart && textEnd != -1 && textEnd < len(line) && line[textEnd] == ':':
+			// A link reference definition, where the rest of the line is the URL and
+			// its optional title.
+			textEnd++
+			urlEnd = len(line)
+		default:
+			return -1
+		}
+		add(marks|mdLinkText, col)
+		add(marks|mdLinkURL, textEnd)
+		add(marks, urlEnd)
+		return urlEnd
+	case '<':
+		end := bytes.IndexByte(line[col:], '>')
+		if end < 2 {
+			return -1
 		}
-		sb.startNewRow()
+		end += col + 1
+		if inner := line[col+1 : end-1]; bytes.ContainsAny(inner, " \t<") || !bytes.ContainsAny(inner, ":@") {
+			return -1
+		}
+		add(marks|mdLinkURL, col)
+		add(marks, end)
+		return end
+	case 'h', 'w':
+		if col > 0 && isWordChar(line[col-1]) {
+			return -1
+		}
+		end := bareURLEnd(line, col)
+		if end == -1 {
+			return -1
+		}
+		add(marks|mdLinkURL, col)
+		add(marks, end)
+		return end
+	}
+	return -1
+}
+
+// linkEnds returns the end of the bracketed text that starts at the given column along with
+// the end of the `(destination "title")` or `[reference]` that directly follows it. Either
+// of them is -1 if there isn't one.
+func linkEnds(line []byte, col int) (textEnd, urlEnd int) {
+	textEnd = closingEnd(line, col, '[', ']')
+	if textEnd == -1 || textEnd == len(line) {
+		return textEnd, -1
+	}
+	switch line[textEnd] {
+	case '(':
+		return textEnd, closingEnd(line, textEnd, '(', ')')
+	case '[':
+		return textEnd, closingEnd(line, textEnd, '[', ']')
+	}
+	return textEnd, -1
+}
+
+// closingEnd returns the column right after the delimiter that closes the one at the given
+// column (skipping over any nested pairs and escaped characters), or -1 if it isn't closed
+// on the same line.
+func closingEnd(line []byte, col int, open, close byte) int {
+	depth := 0
+	for i := col; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case open:
+			depth++
+		case close:
+			if depth--; depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
+
+// bareURLEnd returns the end of the bare URL (one starting with `http://`, `https://` or
+// `www.`) at the given column, or -1 if there isn't one. Any trailing punctuation and
+// unbalanced closing parentheses aren't considered to be a part of the URL.
+func bareURLEnd(line []byte, col int) int {
+	rest := line[col:]
+	if !bytes.HasPrefix(rest, []byte("http://")) && !bytes.HasPrefix(rest, []byte("https://")) && !bytes.HasPrefix(rest, []byte("www.")) {
+		return -1
 	}
+	end := col
+	for end < len(line) && !isSpace(line[end]) && line[end] != '<' {
+		end++
+	}
+	for end > col {
+		switch line[end-1] {
+		case '.', ',', ':', ';', '!', '?', '*', '_', '~', '\'', '"':
+			end--
+			continue
+		case ')':
+			if bytes.Count(line[col:end], []byte("(")) < bytes.Count(line[col:end], []byte(")")) {
+				end--
+				continue
+			}
+		}
+		break
+	}
+	if end-col <= len("www.") {
+		return -1
+	}
+	return end
+}
 
-	return sb.markers
+// isTableDelimRow reports whether the given line is the delimiter row of a table (the one
+// right below its header), such as `| :--- | ---: |`.
+func isTableDelimRow(line []byte) bool {
+	line = bytes.TrimSpace(line)
+	if bytes.IndexByte(line, '|') == -1 {
+		return false
+	}
+	line = bytes.TrimPrefix(bytes.TrimSuffix(line, []byte("|")), []byte("|"))
+	for _, cell := range bytes.Split(line, []byte("|")) {
+		cell = bytes.TrimSpace(cell)
+		cell = bytes.TrimPrefix(bytes.TrimSuffix(cell, []byte(":")), []byte(":"))
+		if len(cell) == 0 || len(bytes.Trim(cell, "-")) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// isSetextUnderline reports whether the given line could be the underline of a setext
+// heading, which is a sequence of either `=` or `-` characters.
+func isSetextUnderline(line []byte) bool {
+	i := 0
+	for i < len(line) && i < 3 && line[i] == ' ' {
+		i++
+	}
+	if i == len(line) || (line[i] != '=' && line[i] != '-') {
+		return false
+	}
+	rest := bytes.TrimRight(line[i:], " \t")
+	return len(bytes.Trim(rest, string(line[i]))) == 0
 }
 
 func isPunct(char byte) bool {
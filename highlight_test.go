@@ -0,0 +1,149 @@
+package mdedit
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// genMarkdown returns a document of about the given number of lines that has a bit of
+// everything the highlighter looks at.
+func genMarkdown(lines int) []byte {
+	var b strings.Builder
+	b.WriteString("---\ntitle: Generated\n---\n")
+	for i := 0; strings.Count(b.String(), "\n") < lines; i++ {
+		fmt.Fprintf(&b, "## Section %d\n\n", i)
+		b.WriteString("Some *italic*, **strong**, `code` and ~~struck~~ text with a [link](https://example.com).\n")
+		b.WriteString("> A quote that goes on\n> for a couple of lines.\n\n")
+		b.WriteString("- [ ] a task\n- [x] a done task\n  1. nested[^1]\n\n")
+		b.WriteString("```go\nfunc main() {\n\tfmt.Println(\"hi\") // comment\n}\n```\n\n")
+		b.WriteString("| a | b |\n|---|---|\n| 1 | 2 |\n\n")
+		b.WriteString("Setext\n------\n\n$$\nx^2\n$$\n\n***\n\n")
+	}
+	return []byte(b.String())
+}
+
+func BenchmarkHighlightFull(b *testing.B) {
+	var buf buffer
+	buf.set(genMarkdown(5000))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var h mdHighlighter
+		h.highlight(&buf)
+	}
+}
+
+func BenchmarkHighlightEdit(b *testing.B) {
+	var buf buffer
+	buf.set(genMarkdown(5000))
+	var h mdHighlighter
+	h.highlight(&buf)
+	row := len(buf.lines) / 2
+	orig := buf.lines[row].text
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Alternate between the edited line and the original one so that every iteration
+		// has a change to highlight.
+		if i%2 == 0 {
+			buf.lines[row].text = append(append([]byte{}, orig...), 'x')
+		} else {
+			buf.lines[row].text = orig
+		}
+		h.highlight(&buf)
+	}
+}
+
+func TestHighlightIncremental(t *testing.T) {
+	var buf buffer
+	buf.set(genMarkdown(300))
+	var h mdHighlighter
+	h.highlight(&buf)
+	del := func(row int) { buf.lines = append(buf.lines[:row], buf.lines[row+1:]...) }
+	edits := []struct {
+		name string
+		edit func()
+	}{
+		{"append to a line", func() { buf.lines[5].text = append(buf.lines[5].text, " *more"...) }},
+		{"open a fence", func() { buf.insertLines(8, []byte("```")) }},
+		{"close the fence", func() { buf.insertLines(20, []byte("```")) }},
+		{"delete lines", func() { buf.deleteLines(30, 45) }},
+		{"make a setext heading", func() { buf.insertLines(60, []byte("Heading"), []byte("=======")) }},
+		{"start a table", func() { buf.insertLines(70, []byte("| x | y |"), []byte("|---|---|")) }},
+		{"remove the fences", func() { del(8); del(19) }},
+		{"front matter", func() { buf.lines[0].text = []byte("--") }},
+		{"reopen the front matter", func() { buf.lines[0].text = []byte("---") }},
+		{"unclose the front matter", func() { del(2) }},
+		{"close the front matter", func() { buf.insertLines(3, []byte("---")) }},
+		{"clear", func() { buf.set(nil) }},
+	}
+	for _, e := range edits {
+		e.edit()
+		got := h.highlight(&buf)
+		var fresh mdHighlighter
+		if want := fresh.highlight(&buf); !reflect.DeepEqual(got, want) {
+			t.Fatalf("after %q: incremental highlighting differs from a fresh one", e.name)
+		}
+	}
+}
+
+func TestHighlightUnclosedFrontMatter(t *testing.T) {
+	var buf buffer
+	buf.set([]byte("---\ntitle: x\n\n# Heading"))
+	var h mdHighlighter
+	for _, m := range h.highlight(&buf)[0] {
+		if m.value&mdFrontMatter != 0 {
+			t.Fatal("the first line starts front matter that's never closed")
+		}
+	}
+}
+
+func BenchmarkHighlightASTEdit(b *testing.B) {
+	var buf buffer
+	buf.set(genMarkdown(4500))
+	src := buf.text()
+	var h astHighlighter
+	h.useParse(parsedMarks(src, parseMarkdown(src)))
+	h.highlight(&buf)
+	row := len(buf.lines) / 2
+	orig := buf.lines[row].text
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if i%2 == 0 {
+			buf.lines[row].text = append(append([]byte{}, orig...), 'x')
+		} else {
+			buf.lines[row].text = orig
+		}
+		h.highlight(&buf)
+	}
+}
+
+func TestASTHighlighterStaleParse(t *testing.T) {
+	var buf buffer
+	buf.set(genMarkdown(300))
+	src := buf.text()
+	var h astHighlighter
+	lines, parsed := parsedMarks(src, parseMarkdown(src))
+	h.useParse(lines, parsed)
+	if got := h.highlight(&buf); !reflect.DeepEqual(got, parsed) {
+		t.Fatal("the marks of an unchanged text differ from its parse's")
+	}
+	// Only the inserted line should go without the parse's marks until the next parse.
+	const row = 100
+	buf.insertLines(row, []byte("some **new** text"))
+	got := h.highlight(&buf)
+	var fresh mdHighlighter
+	lineMarks := fresh.highlight(&buf)
+	for i := range got {
+		want := lineMarks[i]
+		switch {
+		case i < row:
+			want = parsed[i]
+		case i > row:
+			want = parsed[i-1]
+		}
+		if !reflect.DeepEqual(got[i], want) {
+			t.Fatalf("row %d: got %v, want %v", i, got[i], want)
+		}
+	}
+}
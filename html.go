@@ -0,0 +1,462 @@
+package mdedit
+
+import (
+	"bytes"
+	"html"
+	"image/color"
+	"strconv"
+	"strings"
+
+	"gioui.org/text"
+	"gioui.org/widget/material"
+	"gioui.org/x/richtext"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/util"
+)
+
+// htmlTokenKind is the kind of a piece of HTML.
+type htmlTokenKind uint8
+
+const (
+	htmlText htmlTokenKind = iota
+	htmlStartTag
+	htmlEndTag
+	htmlComment
+	htmlOther // a doctype, processing instruction or CDATA section
+)
+
+type htmlToken struct {
+	kind  htmlTokenKind
+	name  string            // the tag's name, in lower case
+	attrs map[string]string // the tag's attributes, by their names in lower case
+	raw   string            // the source of the token
+}
+
+// htmlTokens splits HTML up into text, tags and comments. A `<` that doesn't start any of
+// those is just text.
+func htmlTokens(s string) []htmlToken {
+	var toks []htmlToken
+	text := 0 // where the text that's yet to be added starts
+	for i := 0; i < len(s); {
+		if s[i] != '<' {
+			i++
+			continue
+		}
+		tok, n := htmlMarkup(s[i:])
+		if n == 0 {
+			i++
+			continue
+		}
+		if text < i {
+			toks = append(toks, htmlToken{kind: htmlText, raw: s[text:i]})
+		}
+		toks = append(toks, tok)
+		i += n
+		text = i
+	}
+	if text < len(s) {
+		toks = append(toks, htmlToken{kind: htmlText, raw: s[text:]})
+	}
+	return toks
+}
+
+// htmlMarkup reads the tag or comment at the start of s, returning its length (or 0 if there
+// isn't one).
+func htmlMarkup(s string) (htmlToken, int) {
+	switch {
+	case strings.HasPrefix(s, "<!--"):
+		n := strings.Index(s[4:], "-->")
+		if n == -1 {
+			return htmlToken{kind: htmlComment, raw: s}, len(s)
+		}
+		return htmlToken{kind: htmlComment, raw: s[:4+n+3]}, 4 + n + 3
+	case strings.HasPrefix(s, "<!") || strings.HasPrefix(s, "<?"):
+		n := strings.IndexByte(s, '>')
+		if n == -1 {
+			return htmlToken{}, 0
+		}
+		return htmlToken{kind: htmlOther, raw: s[:n+1]}, n + 1
+	}
+	tok := htmlToken{kind: htmlStartTag}
+	i := 1
+	if i < len(s) && s[i] == '/' {
+		tok.kind = htmlEndTag
+		i++
+	}
+	start := i
+	for i < len(s) && (isASCIILetter(s[i]) || i > start && (s[i] >= '0' && s[i] <= '9' || s[i] == '-')) {
+		i++
+	}
+	if i == start {
+		return htmlToken{}, 0
+	}
+	tok.name = strings.ToLower(s[start:i])
+	for {
+		for i < len(s) && util.IsSpace(s[i]) {
+			i++
+		}
+		switch {
+		case i == len(s):
+			return htmlToken{}, 0
+		case s[i] == '>':
+			tok.raw = s[:i+1]
+			return tok, i + 1
+		case strings.HasPrefix(s[i:], "/>"):
+			tok.raw = s[:i+2]
+			return tok, i + 2
+		}
+		if tok.kind == htmlEndTag {
+			return htmlToken{}, 0
+		}
+		start = i
+		for i < len(s) && !util.IsSpace(s[i]) && !strings.ContainsRune("=>/\"'", rune(s[i])) {
+			i++
+		}
+		if i == start {
+			return htmlToken{}, 0
+		}
+		name, value := strings.ToLower(s[start:i]), ""
+		for i < len(s) && util.IsSpace(s[i]) {
+			i++
+		}
+		if i < len(s) && s[i] == '=' {
+			i++
+			for i < len(s) && util.IsSpace(s[i]) {
+				i++
+			}
+			if i == len(s) {
+				return htmlToken{}, 0
+			}
+			if q := s[i]; q == '"' || q == '\'' {
+				n := strings.IndexByte(s[i+1:], q)
+				if n == -1 {
+					return htmlToken{}, 0
+				}
+				value, i = s[i+1:i+1+n], i+n+2
+			} else {
+				start = i
+				for i < len(s) && !util.IsSpace(s[i]) && s[i] != '>' {
+					i++
+				}
+				value = s[start:i]
+			}
+		}
+		if tok.attrs == nil {
+			tok.attrs = make(map[string]string)
+		}
+		tok.attrs[name] = html.UnescapeString(value)
+	}
+}
+
+// htmlStyle is an inline HTML element that's been opened but not closed yet.
+type htmlStyle struct {
+	name  string
+	style richtext.SpanStyle // the style of the text from before it
+	from  int                // the index of its first span
+}
+
+// isDetails is the summary of a `<details>` element, which can be clicked to show or hide
+// the blocks within it.
+type isDetails struct {
+	open bool // whether it starts out open
+}
+
+// detailsKey is the key of the metadata of a summary's spans, which is the index of its
+// group.
+const detailsKey = "details"
+
+func (sb *spanBuilder) renderHTMLBlock(_ util.BufWriter, src []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	var b bytes.Buffer
+	for i := 0; i < node.Lines().Len(); i++ {
+		seg := node.Lines().At(i)
+		b.Write(seg.Value(src))
+	}
+	if n := node.(*ast.HTMLBlock); n.HasClosure() {
+		b.Write(n.ClosureLine.Value(src))
+	}
+	for _, tok := range htmlTokens(b.String()) {
+		sb.writeHTML(tok)
+	}
+	// A summary without its own `<summary>` is finished by the end of the block.
+	if sb.summary {
+		sb.endSummary()
+	}
+	switch {
+	case len(sb.lists) > 0 || sb.definitions > 0:
+		sb.endLine()
+	case sb.hasContent() || sb.current.mdata != nil:
+		sb.commitGroup()
+	default:
+		// Nothing was shown, so its source doesn't count toward the next group's.
+		sb.current = spanGroup{}
+		sb.extent = srcExtent{}
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+func (sb *spanBuilder) renderRawHTML(_ util.BufWriter, src []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*ast.RawHTML)
+	var b bytes.Buffer
+	for i := 0; i < n.Segments.Len(); i++ {
+		seg := n.Segments.At(i)
+		b.Write(seg.Value(src))
+	}
+	for _, tok := range htmlTokens(b.String()) {
+		sb.writeHTML(tok)
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+// writeHTML adds a piece of HTML to the current group. Only a few elements that are often
+// used within markdown are supported, and any other tags are shown as they are (but dimmed).
+func (sb *spanBuilder) writeHTML(tok htmlToken) {
+	switch tok.kind {
+	case htmlText:
+		sb.writeHTMLText(tok.raw)
+		return
+	case htmlComment:
+		return
+	case htmlOther:
+		sb.writeHTMLSource(tok.raw)
+		return
+	}
+	nested := len(sb.lists) > 0 || sb.definitions > 0 || sb.table != nil
+	start := tok.kind == htmlStartTag
+	switch tok.name {
+	case "br":
+		if start {
+			sb.currentSpan().Content += "\n"
+		}
+	case "b", "strong", "i", "em", "code", "kbd", "sub", "sup":
+		if start {
+			sb.openHTMLStyle(tok.name)
+		} else {
+			sb.closeHTMLStyle(tok.name)
+		}
+	case "img":
+		if !start {
+			return
+		}
+		src, ok := tok.attrs["src"]
+		if !ok {
+			sb.writeHTMLSource(tok.raw)
+			return
+		}
+		img := isImage{dest: src, alt: tok.attrs["alt"]}
+		img.width, img.widthPct = htmlLength(tok.attrs["width"])
+		if sb.table != nil {
+			sb.currentSpan().Content += img.alt
+			return
+		}
+		sb.addImage(img)
+	case "details":
+		switch {
+		case nested:
+		case start:
+			if sb.summary {
+				sb.endSummary()
+			}
+			if sb.hasContent() {
+				sb.commitGroup()
+			}
+			_, open := tok.attrs["open"]
+			sb.current = spanGroup{mdata: isDetails{open: open}}
+			sb.newSpan(material.Body1(sb.theme, ""))
+			sb.summary = true
+		default:
+			if sb.summary {
+				sb.endSummary()
+			}
+			if sb.hasContent() {
+				sb.commitGroup()
+			}
+			if n := len(sb.sections); n > 0 {
+				sb.sections = sb.sections[:n-1]
+			}
+		}
+	case "summary":
+		if !start && sb.summary {
+			sb.endSummary()
+		}
+	default:
+		sb.writeHTMLSource(tok.raw)
+	}
+}
+
+// writeHTMLText adds the text from between HTML tags, where any run of white space is a
+// single space (and none at the start of a line).
+func (sb *spanBuilder) writeHTMLText(s string) {
+	s = html.UnescapeString(s)
+	var b strings.Builder
+	space := !sb.hasContent() || sb.endsWith('\n') || sb.endsWith(' ')
+	for _, r := range s {
+		if r == ' ' || r == '\t' || r == '\n' || r == '\r' {
+			if !space {
+				b.WriteByte(' ')
+			}
+			space = true
+			continue
+		}
+		b.WriteRune(r)
+		space = false
+	}
+	sb.currentSpan().Content += b.String()
+}
+
+// endsWith reports whether the text of the current group ends with the given byte.
+func (sb *spanBuilder) endsWith(c byte) bool {
+	for i := len(sb.current.items) - 1; i >= 0; i-- {
+		if s := sb.current.items[i].Content; s != "" {
+			return s[len(s)-1] == c
+		}
+	}
+	return false
+}
+
+// writeHTMLSource adds the source of an HTML tag that isn't supported, dimmed.
+func (sb *spanBuilder) writeHTMLSource(raw string) {
+	style := *sb.currentSpan()
+	style.Content = ""
+	dimmed := style
+	dimmed.Color.A /= 2
+	dimmed.Content = raw
+	sb.current.items = append(sb.current.items, dimmed, style)
+}
+
+// openHTMLStyle starts the style of an inline HTML element.
+func (sb *spanBuilder) openHTMLStyle(name string) {
+	style := *sb.currentSpan()
+	style.Content = ""
+	sb.htmlStyles = append(sb.htmlStyles, htmlStyle{name: name, style: style, from: len(sb.current.items)})
+	switch name {
+	case "b", "strong":
+		style.Font.Weight = text.Bold
+	case "i", "em":
+		style.Font.Style = text.Italic
+	case "code":
+		style.Font.Variant = "Mono"
+		style.Color = color.NRGBA{162, 120, 70, 255}
+	case "kbd":
+		style.Font.Variant = "Mono"
+		style.Font.Weight = text.Bold
+	case "sub", "sup":
+		style.Size *= 0.75
+	}
+	sb.current.items = append(sb.current.items, style)
+}
+
+// closeHTMLStyle goes back to the style of the text from before the innermost open element
+// with the given name (closing any that were opened within it). A closing tag without an
+// opening one is left out.
+func (sb *spanBuilder) closeHTMLStyle(name string) {
+	for i := len(sb.htmlStyles) - 1; i >= 0; i-- {
+		hs := sb.htmlStyles[i]
+		if hs.name != name {
+			continue
+		}
+		sb.htmlStyles = sb.htmlStyles[:i]
+		if name == "sub" || name == "sup" {
+			sb.useScriptChars(hs, name)
+		}
+		sb.current.items = append(sb.current.items, hs.style)
+		return
+	}
+}
+
+// useScriptChars turns the text of a `<sub>` or `<sup>` element into subscript or
+// superscript characters (in the size of the text around it) if there are ones for all of it.
+func (sb *spanBuilder) useScriptChars(hs htmlStyle, name string) {
+	if hs.from > len(sb.current.items) {
+		return
+	}
+	chars := texSuperscripts
+	if name == "sub" {
+		chars = texSubscripts
+	}
+	spans := sb.current.items[hs.from:]
+	mapped := make([]string, len(spans))
+	for i, s := range spans {
+		rs := []rune(strings.ReplaceAll(s.Content, "-", "−"))
+		for j, r := range rs {
+			c, ok := chars[r]
+			if !ok {
+				return
+			}
+			rs[j] = c
+		}
+		mapped[i] = string(rs)
+	}
+	for i := range spans {
+		spans[i].Content = mapped[i]
+		spans[i].Size = hs.style.Size
+	}
+}
+
+// endSummary finishes the summary of a `<details>` element, after which the groups are
+// within its section until it's closed.
+func (sb *spanBuilder) endSummary() {
+	sb.summary = false
+	if !sb.hasContent() {
+		sb.currentSpan().Content = "Details"
+	}
+	i := len(sb.result)
+	for j := range sb.current.items {
+		sb.current.items[j].Interactive = true
+		sb.setMeta(j, detailsKey, i)
+	}
+	sb.commitGroup()
+	sb.sections = append(sb.sections, i+1)
+}
+
+// htmlLength parses the value of a `width` attribute, which is either in pixels (taken to be
+// Dp) or a percentage of the width of the document.
+func htmlLength(s string) (length float32, pct bool) {
+	s = strings.TrimSpace(s)
+	if strings.HasSuffix(s, "%") {
+		s, pct = s[:len(s)-1], true
+	}
+	v, err := strconv.ParseFloat(strings.TrimSuffix(s, "px"), 32)
+	if err != nil || v <= 0 {
+		return 0, false
+	}
+	return float32(v), pct
+}
+
+// detailsOpen reports whether the section of the `<details>` element whose summary is the
+// element at the given index is shown.
+func (d *Document) detailsOpen(i int) bool {
+	return d.elements[i].mdata.(isDetails).open != d.states[i].toggled
+}
+
+// hidden reports whether the element at the given index is within a `<details>` element (or
+// more than one) that's closed.
+func (d *Document) hidden(i int) bool {
+	for s := d.elements[i].section; s != 0; s = d.elements[s-1].section {
+		if !d.detailsOpen(s - 1) {
+			return true
+		}
+	}
+	return false
+}
+
+// layDetails lays out the summary of a `<details>` element after a marker of whether it's
+// open.
+func (d *Document) layDetails(gtx C, th *material.Theme, blk *spanGroup, st *blockState) D {
+	marker := blk.items[0]
+	marker.Content = "▸ "
+	if blk.mdata.(isDetails).open != st.toggled {
+		marker.Content = "▾ "
+	}
+	items := append([]richtext.SpanStyle{marker}, blk.items...)
+	var flow []flowSpan
+	if blk.flow != nil {
+		flow = append([]flowSpan{{meta: blk.flow[0].meta}}, blk.flow...)
+	}
+	return d.layText(gtx, th, items, flow, &st.text, &st.flow)
+}
@@ -0,0 +1,174 @@
+package mdedit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"net/url"
+	"path"
+	"sync"
+
+	"gioui.org/f32"
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"gioui.org/widget/material"
+	"gioui.org/x/richtext"
+	_ "golang.org/x/image/webp"
+)
+
+// imageCache holds the images that documents show, which are loaded in the background. A
+// Session's documents all share one.
+type imageCache struct {
+	fsys       FS
+	invalidate func() // called whenever an image has finished loading
+	mu         sync.Mutex
+	images     map[string]*cachedImage
+}
+
+type cachedImage struct {
+	loaded bool
+	img    paint.ImageOp
+	err    error
+}
+
+func newImageCache(fsys FS, invalidate func()) *imageCache {
+	return &imageCache{
+		fsys:       fsys,
+		invalidate: invalidate,
+		images:     make(map[string]*cachedImage),
+	}
+}
+
+// get returns the image at the given path. If it hasn't been loaded, it starts loading and
+// get returns false until it's done.
+func (c *imageCache) get(fpath string) (cachedImage, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ci, ok := c.images[fpath]; ok {
+		return *ci, ci.loaded
+	}
+	c.images[fpath] = &cachedImage{}
+	go c.load(fpath)
+	return cachedImage{}, false
+}
+
+func (c *imageCache) load(fpath string) {
+	var ci cachedImage
+	data, err := c.fsys.ReadFile(fpath)
+	if err == nil {
+		var img image.Image
+		if img, _, err = image.Decode(bytes.NewReader(data)); err == nil {
+			ci.img = paint.NewImageOp(img)
+		}
+	}
+	if err != nil {
+		ci.err = fmt.Errorf("loading '%s': %w", fpath, err)
+	}
+	ci.loaded = true
+	c.mu.Lock()
+	c.images[fpath] = &ci
+	c.mu.Unlock()
+	if c.invalidate != nil {
+		c.invalidate()
+	}
+}
+
+// isImage is an image within a document, which is laid out as a block of its own.
+type isImage struct {
+	dest     string
+	alt      string
+	width    float32 // the width it's shown at (in Dp) if it's set, as in `<img width="200">`
+	widthPct bool    // whether the width is a percentage of the document's width instead
+}
+
+// imagePath returns the path of the file that an image's destination refers to, where a
+// relative one is relative to the document's directory.
+func (d *Document) imagePath(dest string) (string, error) {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "" || u.Host != "" {
+		return "", errors.New("only local images are shown")
+	}
+	if path.IsAbs(u.Path) {
+		return u.Path, nil
+	}
+	return path.Join(d.dir, u.Path), nil
+}
+
+// layImage lays out an image scaled down to fit the width (if needed). The alt text is shown
+// instead while it's loading, and along with the error if it can't be loaded.
+func (d *Document) layImage(gtx C, th *material.Theme, img isImage, state *richtext.InteractiveText) D {
+	fpath, err := d.imagePath(img.dest)
+	var ci cachedImage
+	if err == nil {
+		if d.images == nil {
+			err = errors.New("no images can be loaded")
+		} else {
+			var loaded bool
+			if ci, loaded = d.images.get(fpath); !loaded {
+				l := material.Body2(th, img.alt)
+				l.Color.A = 120
+				return l.Layout(gtx)
+			}
+			err = ci.err
+		}
+	}
+	if err != nil {
+		return layImageError(gtx, th, img.alt, err, state)
+	}
+
+	size := layout.FPt(ci.img.Size()).Mul(gtx.Metric.PxPerDp)
+	switch {
+	case img.width > 0 && img.widthPct:
+		size = size.Mul(float32(gtx.Constraints.Max.X) * img.width / 100 / size.X)
+	case img.width > 0:
+		size = size.Mul(img.width * gtx.Metric.PxPerDp / size.X)
+	}
+	if max := float32(gtx.Constraints.Max.X); size.X > max {
+		size = size.Mul(max / size.X)
+	}
+	dims := image.Point{X: int(size.X), Y: int(size.Y)}
+	defer clip.Rect{Max: dims}.Push(gtx.Ops).Pop()
+	scale := size.X / float32(ci.img.Size().X)
+	defer op.Affine(f32.Affine2D{}.Scale(f32.Point{}, f32.Pt(scale, scale))).Push(gtx.Ops).Pop()
+	ci.img.Add(gtx.Ops)
+	paint.PaintOp{}.Add(gtx.Ops)
+	return D{Size: dims}
+}
+
+// layImageError lays out the placeholder of an image that can't be shown.
+func layImageError(gtx C, th *material.Theme, alt string, err error, state *richtext.InteractiveText) D {
+	altStyle := material.Body1(th, "")
+	errStyle := material.Body2(th, "")
+	errStyle.Color = color.NRGBA{220, 90, 80, 255}
+	spans := []richtext.SpanStyle{
+		{Font: errStyle.Font, Size: errStyle.TextSize, Color: errStyle.Color, Content: err.Error()},
+	}
+	if alt != "" {
+		spans = append([]richtext.SpanStyle{
+			{Font: altStyle.Font, Size: altStyle.TextSize, Color: altStyle.Color, Content: alt + "\n"},
+		}, spans...)
+	}
+	m := op.Record(gtx.Ops)
+	dims := layout.UniformInset(10).Layout(gtx, func(gtx C) D {
+		return richtext.Text(state, th.Shaper, spans...).Layout(gtx)
+	})
+	call := m.Stop()
+	rect := image.Rectangle{Max: dims.Size}
+	paint.FillShape(gtx.Ops, color.NRGBA{220, 90, 80, 20}, clip.UniformRRect(rect, 4).Op(gtx.Ops))
+	paint.FillShape(gtx.Ops, color.NRGBA{220, 90, 80, 120}, clip.Stroke{
+		Path:  clip.UniformRRect(rect, 4).Path(gtx.Ops),
+		Width: 1,
+	}.Op())
+	call.Add(gtx.Ops)
+	return dims
+}
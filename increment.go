@@ -0,0 +1,96 @@
+package mdedit
+
+import (
+	"strconv"
+	"time"
+)
+
+const isoDate = "2006-01-02"
+
+// numToken is a number (or an ISO date) within a line of text.
+type numToken struct {
+	start  int
+	end    int
+	isDate bool
+}
+
+// numTokens returns all of the numbers and ISO dates within the given text in order.
+func numTokens(text []byte) (toks []numToken) {
+	for i := 0; i < len(text); i++ {
+		if !isDigit(text[i]) {
+			continue
+		}
+		j := i
+		for j < len(text) && isDigit(text[j]) {
+			j++
+		}
+		if j-i == 4 && j+6 <= len(text) && (j+6 == len(text) || !isDigit(text[j+6])) {
+			if _, err := time.Parse(isoDate, string(text[i:j+6])); err == nil {
+				toks = append(toks, numToken{start: i, end: j + 6, isDate: true})
+				i = j + 5
+				continue
+			}
+		}
+		// A minus sign counts as part of the number unless it's in between two words
+		// (such as in `foo-123`).
+		start := i
+		if i > 0 && text[i-1] == '-' && (i == 1 || !isWordChar(text[i-2])) {
+			start--
+		}
+		toks = append(toks, numToken{start: start, end: j})
+		i = j - 1
+	}
+	return toks
+}
+
+// increment adds n to the first number (or n days to the first ISO date) that ends after
+// column `from` and, if `to` isn't negative, starts before column `to`. Numbers with
+// leading zeros keep their width. It returns the column of the last character of the
+// updated number, or false if no number was found.
+func (ln *line) increment(from, to, n int) (int, bool) {
+	for _, tok := range numTokens(ln.text) {
+		if tok.end <= from {
+			continue
+		}
+		if to >= 0 && tok.start >= to {
+			return 0, false
+		}
+		s := string(ln.text[tok.start:tok.end])
+		var repl string
+		if tok.isDate {
+			t, _ := time.Parse(isoDate, s)
+			repl = t.AddDate(0, 0, n).Format(isoDate)
+		} else {
+			v, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return 0, false
+			}
+			repl = strconv.FormatInt(v+int64(n), 10)
+			// Keep any leading zeros by padding the new number to the old width.
+			if digits := s[len(s)-len(trimSign(s)):]; len(digits) > 1 && digits[0] == '0' {
+				sign, abs := "", repl
+				if repl[0] == '-' {
+					sign, abs = "-", repl[1:]
+				}
+				for len(abs) < len(digits) {
+					abs = "0" + abs
+				}
+				repl = sign + abs
+			}
+		}
+		ln.text = append(ln.text[:tok.start], append([]byte(repl), ln.text[tok.end:]...)...)
+		return tok.start + len(repl) - 1, true
+	}
+	return 0, false
+}
+
+func trimSign(s string) string {
+	if len(s) > 0 && s[0] == '-' {
+		return s[1:]
+	}
+	return s
+}
+
+func isDigit(char byte) bool {
+	return char >= '0' && char <= '9'
+}
@@ -0,0 +1,92 @@
+package mdedit
+
+import (
+	"log"
+	"net/url"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// followLink follows a link (that was clicked in the document of the given tab) to the given
+// destination. Links to other markdown files are opened in a tab (or switch to the tab that
+// they're already open in), and web and email links are opened with the system's default
+// handler. Anything else (such as a link to a program) isn't followed, since a document
+// shouldn't be able to run things with a single click.
+func (s *Session) followLink(from *markdownTab, dest string) {
+	u, err := url.Parse(dest)
+	if err != nil {
+		log.Printf("parsing link '%s': %v\n", dest, err)
+		return
+	}
+	if u.Scheme != "" || u.Host != "" {
+		switch strings.ToLower(u.Scheme) {
+		case "http", "https", "mailto":
+			openExternal(dest)
+		default:
+			log.Printf("not following link '%s': only http, https and mailto links are opened\n", dest)
+		}
+		return
+	}
+	fpath := u.Path
+	if !path.IsAbs(fpath) {
+		fpath = path.Join(path.Dir(from.name), fpath)
+	}
+	switch strings.ToLower(path.Ext(fpath)) {
+	case ".md", ".markdown":
+	default:
+		log.Printf("not following link '%s': only markdown files are opened\n", dest)
+		return
+	}
+	t := s.findMarkdownTab(fpath)
+	if t == -1 {
+		if err := s.openFile(fpath); err != nil {
+			log.Printf("following link '%s': %v\n", dest, err)
+			return
+		}
+		t = len(s.tabs) - 1
+	}
+	s.SelectTab(t)
+	if md, ok := s.tabs[s.activeTab].content.(*markdownTab); ok && u.Fragment != "" {
+		md.view.document.ScrollToHeading(u.Fragment)
+	}
+}
+
+// findMarkdownTab returns the index of the tab that has the given file open, or -1 if there
+// isn't one.
+func (s *Session) findMarkdownTab(fpath string) int {
+	if path.IsAbs(fpath) {
+		if rel, err := filepath.Rel(s.fsys.WorkingDir(), fpath); err == nil {
+			fpath = rel
+		}
+	}
+	fpath = path.Clean(fpath)
+	for i := range s.tabs {
+		if md, ok := s.tabs[i].content.(*markdownTab); ok && path.Clean(md.name) == fpath {
+			return i
+		}
+	}
+	return -1
+}
+
+// openExternal opens the given URL with whatever the system uses for it.
+func openExternal(target string) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", target)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", target)
+	default:
+		cmd = exec.Command("xdg-open", target)
+	}
+	if err := cmd.Start(); err != nil {
+		log.Printf("opening '%s': %v\n", target, err)
+		return
+	}
+	go func() {
+		_ = cmd.Wait()
+	}()
+}
@@ -0,0 +1,364 @@
+package mdedit
+
+import "strconv"
+
+// listPrefix describes the leading markup of a line that gets carried over onto new lines:
+// indentation, blockquote markers and a list item marker (with an optional task checkbox).
+// All fields that are positions are byte indexes into the line's text.
+type listPrefix struct {
+	indent    int  // end of the leading whitespace
+	lead      int  // end of the indentation and any blockquote markers
+	quoted    bool // whether there are any blockquote markers
+	bullet    byte // '-', '*' or '+' for bullet items, the delimiter ('.' or ')') for ordered items
+	ordered   bool
+	number    int
+	markerEnd int  // end of the list item marker (right after the bullet or delimiter)
+	body      int  // end of the spacing after the marker, which is where nested items line up
+	task      bool // whether the item starts with a `[ ]` or `[x]` checkbox
+	width     int  // column at which the line's content starts
+}
+
+func parseListPrefix(text []byte) (lp listPrefix) {
+	i := 0
+	for i < len(text) && (text[i] == ' ' || text[i] == '\t') {
+		i++
+	}
+	lp.indent = i
+	for i < len(text) && text[i] == '>' {
+		lp.quoted = true
+		i++
+		for i < len(text) && (text[i] == ' ' || text[i] == '\t') {
+			i++
+		}
+	}
+	lp.lead = i
+	lp.width = i
+	// Bullet or ordered list item marker.
+	switch {
+	case i < len(text) && (text[i] == '-' || text[i] == '*' || text[i] == '+'):
+		// A line like `* * *` is a thematic break rather than a list item.
+		if !isMarkerEnd(text, i+1) || isThematicBreak(text[i:]) {
+			return
+		}
+		lp.bullet = text[i]
+		i++
+	case i < len(text) && text[i] >= '0' && text[i] <= '9':
+		j := i
+		for j < len(text) && j-i < 9 && text[j] >= '0' && text[j] <= '9' {
+			j++
+		}
+		if j == len(text) || (text[j] != '.' && text[j] != ')') || !isMarkerEnd(text, j+1) {
+			return
+		}
+		lp.number, _ = strconv.Atoi(string(text[i:j]))
+		lp.ordered = true
+		lp.bullet = text[j]
+		i = j + 1
+	default:
+		return
+	}
+	lp.markerEnd = i
+	for i < len(text) && (text[i] == ' ' || text[i] == '\t') {
+		i++
+	}
+	if i == lp.markerEnd {
+		// An empty item without the trailing space still lines up as if it had one.
+		lp.body = i + 1
+	} else {
+		lp.body = i
+	}
+	lp.width = i
+	// Task item checkbox.
+	if i+2 < len(text) && text[i] == '[' && text[i+2] == ']' && isMarkerEnd(text, i+3) {
+		switch text[i+1] {
+		case ' ', 'x', 'X':
+			lp.task = true
+			i += 3
+			for i < len(text) && (text[i] == ' ' || text[i] == '\t') {
+				i++
+			}
+			lp.width = i
+		}
+	}
+	return
+}
+
+// isMarkerEnd reports whether index i of the given text is either the end of the text or
+// a space, which is what has to follow a list item marker.
+func isMarkerEnd(text []byte, i int) bool {
+	return i == len(text) || text[i] == ' ' || text[i] == '\t'
+}
+
+// isThematicBreak reports whether the text is three or more of the same `-`, `*` or `_`
+// character, with nothing but spaces or tabs between them.
+func isThematicBreak(text []byte) bool {
+	if len(text) == 0 || (text[0] != '-' && text[0] != '*' && text[0] != '_') {
+		return false
+	}
+	n := 0
+	for _, c := range text {
+		switch c {
+		case text[0]:
+			n++
+		case ' ', '\t':
+		default:
+			return false
+		}
+	}
+	return n >= 3
+}
+
+// isListItem reports whether the prefix has a bullet or ordered list item marker.
+func (lp *listPrefix) isListItem() bool {
+	return lp.markerEnd != 0
+}
+
+// continues reports whether there is any markup in the prefix that should be carried over
+// to a new line.
+func (lp *listPrefix) continues() bool {
+	return lp.quoted || lp.isListItem()
+}
+
+// continuation returns the prefix a new line should start with in order to continue the
+// given line (which must be the text this prefix was parsed from). Ordered item numbers
+// are offset by the given amount.
+func (lp *listPrefix) continuation(text []byte, numOffset int) []byte {
+	pfx := append([]byte{}, text[:lp.lead]...)
+	if !lp.isListItem() {
+		return pfx
+	}
+	if lp.ordered {
+		pfx = strconv.AppendInt(pfx, int64(lp.number+numOffset), 10)
+	}
+	pfx = append(pfx, lp.bullet)
+	// Keep the spacing between the marker and the content (but always have some).
+	spaceEnd := lp.markerEnd
+	for spaceEnd < len(text) && (text[spaceEnd] == ' ' || text[spaceEnd] == '\t') {
+		spaceEnd++
+	}
+	if spaceEnd == lp.markerEnd {
+		pfx = append(pfx, ' ')
+	} else {
+		pfx = append(pfx, text[lp.markerEnd:spaceEnd]...)
+	}
+	if lp.task {
+		pfx = append(pfx, "[ ] "...)
+	}
+	return pfx
+}
+
+// setListNumber replaces the number of the ordered list item on the given line.
+func (ln *line) setListNumber(lp *listPrefix, n int) {
+	num := strconv.AppendInt(nil, int64(n), 10)
+	rest := ln.text[lp.markerEnd-1:]
+	ln.text = append(append(append(make([]byte, 0, len(ln.text)+len(num)), ln.text[:lp.lead]...), num...), rest...)
+}
+
+// renumberList renumbers the ordered list items that follow the given row and belong to
+// the same list as the given prefix, continuing on from the prefix's number. Nested items
+// and continuation lines are skipped, and the list is considered over at the first line
+// that is less indented or that is a non-item after a blank line.
+func (b *buffer) renumberList(row int, first listPrefix) {
+	if !first.ordered {
+		return
+	}
+	n := first.number
+	prevBlank := false
+	for r := row + 1; r < len(b.lines); r++ {
+		ln := &b.lines[r]
+		lp := parseListPrefix(ln.text)
+		if lp.lead == len(ln.text) {
+			prevBlank = true
+			continue
+		}
+		switch {
+		case lp.quoted != first.quoted:
+			return
+		case lp.lead > first.lead:
+			// A nested item or a continuation line.
+		case lp.lead == first.lead && lp.ordered && lp.bullet == first.bullet:
+			n++
+			if lp.number != n {
+				ln.setListNumber(&lp, n)
+			}
+		case lp.lead < first.lead, lp.isListItem(), prevBlank:
+			return
+		}
+		prevBlank = false
+	}
+}
+
+// shiftWidth is the number of spaces that lines which aren't list items are shifted by.
+const shiftWidth = 4
+
+// shiftLines shifts the given range of rows (inclusive) one level to the right or left. List
+// items are shifted along with their children, and they are nested under (or moved out
+// from under) their previous sibling (or parent) by lining them up with its content. Any
+// affected ordered lists are renumbered. The cursor is put on the first non-blank character
+// of the first row.
+func (b *buffer) shiftLines(y1, y2 int, right bool) {
+	for row := y1; row <= y2; {
+		lp := parseListPrefix(b.lines[row].text)
+		if lp.lead == len(b.lines[row].text) {
+			row++
+			continue
+		}
+		if !lp.isListItem() {
+			d := shiftWidth
+			if !right {
+				d = -d
+			}
+			b.lines[row].shift(d)
+			row++
+			continue
+		}
+		end := b.itemEnd(row)
+		if right {
+			b.nestItem(row, end, lp)
+		} else {
+			b.unnestItem(row, end, lp)
+		}
+		row = end + 1
+	}
+	b.cursor.row = y1
+	b.cursor.col = b.lines[y1].startingIndex()
+	b.prefCol = b.cursor.col
+}
+
+// nestItem shifts the list item spanning the given rows so that it becomes a child of its
+// previous sibling.
+func (b *buffer) nestItem(row, end int, lp listPrefix) {
+	d := lp.body - lp.lead
+	prev := b.prevSibling(row)
+	if prev != -1 {
+		if pp := parseListPrefix(b.lines[prev].text); pp.body > lp.lead {
+			d = pp.body - lp.lead
+		}
+	}
+	for r := row; r <= end; r++ {
+		b.lines[r].shift(d)
+	}
+	// Renumber the list the item joined as well as the one it left.
+	if sib := b.prevSibling(row); sib != -1 {
+		b.renumberList(sib, parseListPrefix(b.lines[sib].text))
+	} else {
+		b.restartList(row)
+	}
+	if prev != -1 {
+		b.renumberList(prev, parseListPrefix(b.lines[prev].text))
+	}
+}
+
+// unnestItem shifts the list item spanning the given rows so that it lines up with its
+// parent (or, if it has none, just removes one marker's width of indentation). Any siblings
+// that come after the item become its children.
+func (b *buffer) unnestItem(row, end int, lp listPrefix) {
+	d := lp.lead - lp.body
+	if parent := b.parentItem(row); parent != -1 {
+		d = parseListPrefix(b.lines[parent].text).lead - lp.lead
+	}
+	for r := row; r <= end; r++ {
+		b.lines[r].shift(d)
+	}
+	// The item's former next sibling (if any) now starts a list nested under the item.
+	if next := end + 1; next < len(b.lines) {
+		if np := parseListPrefix(b.lines[next].text); np.isListItem() && np.lead == lp.lead {
+			b.restartList(next)
+		}
+	}
+	if sib := b.prevSibling(row); sib != -1 {
+		b.renumberList(sib, parseListPrefix(b.lines[sib].text))
+	} else {
+		b.renumberList(row, parseListPrefix(b.lines[row].text))
+	}
+}
+
+// restartList makes the ordered list item on the given row number one and renumbers the
+// rest of its list accordingly.
+func (b *buffer) restartList(row int) {
+	lp := parseListPrefix(b.lines[row].text)
+	if !lp.ordered {
+		return
+	}
+	if lp.number != 1 {
+		b.lines[row].setListNumber(&lp, 1)
+		lp = parseListPrefix(b.lines[row].text)
+	}
+	b.renumberList(row, lp)
+}
+
+// itemEnd returns the last row of the list item on the given row, which includes any
+// nested items and continuation lines (but not trailing blank lines).
+func (b *buffer) itemEnd(row int) int {
+	lead := parseListPrefix(b.lines[row].text).lead
+	end := row
+	for r := row + 1; r < len(b.lines); r++ {
+		lp := parseListPrefix(b.lines[r].text)
+		if lp.lead == len(b.lines[r].text) {
+			continue
+		}
+		if lp.lead <= lead {
+			break
+		}
+		end = r
+	}
+	return end
+}
+
+// prevSibling returns the row of the list item before the one on the given row that is at
+// the same level of the same list, or -1 if there isn't one.
+func (b *buffer) prevSibling(row int) int {
+	lead := parseListPrefix(b.lines[row].text).lead
+	for r := row - 1; r >= 0; r-- {
+		lp := parseListPrefix(b.lines[r].text)
+		switch {
+		case lp.lead == len(b.lines[r].text), lp.lead > lead:
+			continue
+		case lp.lead == lead && lp.isListItem():
+			return r
+		}
+		return -1
+	}
+	return -1
+}
+
+// parentItem returns the row of the list item that the item on the given row is nested
+// under, or -1 if there isn't one.
+func (b *buffer) parentItem(row int) int {
+	lead := parseListPrefix(b.lines[row].text).lead
+	for r := row - 1; r >= 0; r-- {
+		lp := parseListPrefix(b.lines[r].text)
+		switch {
+		case lp.lead == len(b.lines[r].text), lp.lead >= lead:
+			continue
+		case lp.isListItem():
+			return r
+		case lp.lead == 0:
+			return -1
+		}
+	}
+	return -1
+}
+
+// shift indents the line by n spaces, or, if n is negative, removes up to -n characters of
+// indentation. For lines in a blockquote, this happens after the blockquote markers (and
+// the one space following them is kept).
+func (ln *line) shift(n int) {
+	lead := parseListPrefix(ln.text).lead
+	if n > 0 {
+		spaces := make([]byte, n)
+		for i := range spaces {
+			spaces[i] = ' '
+		}
+		ln.text = append(ln.text[:lead], append(spaces, ln.text[lead:]...)...)
+		return
+	}
+	floor := lead
+	for floor > 0 && (ln.text[floor-1] == ' ' || ln.text[floor-1] == '\t') {
+		floor--
+	}
+	if floor > 0 && ln.text[floor-1] == '>' {
+		floor = min(floor+1, lead)
+	}
+	ln.deleteRange(max(floor, lead+n), lead)
+}
@@ -0,0 +1,21 @@
+package mdedit
+
+import "testing"
+
+func TestParseListPrefixThematicBreak(t *testing.T) {
+	for text, isItem := range map[string]bool{
+		"* * *":   false,
+		"- - -":   false,
+		"***":     false,
+		"> - - -": false,
+		"- -":     true,
+		"* a":     true,
+		"- ***":   true,
+		"- - a":   true,
+	} {
+		lp := parseListPrefix([]byte(text))
+		if lp.isListItem() != isItem {
+			t.Errorf("%q: got a list item %v, want %v", text, lp.isListItem(), isItem)
+		}
+	}
+}
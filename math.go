@@ -0,0 +1,245 @@
+package mdedit
+
+import (
+	"bytes"
+	"image"
+
+	"gioui.org/layout"
+	"gioui.org/text"
+	"gioui.org/widget/material"
+	"gioui.org/x/richtext"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	gmtext "github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+var kindMathBlock = ast.NewNodeKind("MathBlock")
+
+// mathBlock is a block of TeX math between lines of `$$`. Its lines are the TeX source.
+type mathBlock struct {
+	ast.BaseBlock
+	open  int  // the offset of the opening `$$`
+	close int  // the offset of the closing `$$`, or -1 if the block isn't closed
+	done  bool // whether the block was closed on its opening line (as in `$$ x $$`)
+}
+
+func (n *mathBlock) Kind() ast.NodeKind { return kindMathBlock }
+
+func (n *mathBlock) IsRaw() bool { return true }
+
+func (n *mathBlock) Dump(src []byte, level int) {
+	ast.DumpHelper(n, src, level, nil, nil)
+}
+
+var kindMathInline = ast.NewNodeKind("MathInline")
+
+// mathInline is TeX math within a line of text, between either `$` or `$$`.
+type mathInline struct {
+	ast.BaseInline
+	Segment gmtext.Segment // the TeX source, without the delimiters
+	display bool           // whether it's delimited by `$$`
+}
+
+func (n *mathInline) Kind() ast.NodeKind { return kindMathInline }
+
+func (n *mathInline) Dump(src []byte, level int) {
+	ast.DumpHelper(n, src, level, map[string]string{"Source": string(n.Segment.Value(src))}, nil)
+}
+
+// delim returns the length of the math's delimiters.
+func (n *mathInline) delim() int {
+	if n.display {
+		return 2
+	}
+	return 1
+}
+
+type mathBlockParser struct{}
+
+func (mathBlockParser) Trigger() []byte {
+	return []byte{'$'}
+}
+
+func (mathBlockParser) Open(_ ast.Node, reader gmtext.Reader, pc parser.Context) (ast.Node, parser.State) {
+	line, segment := reader.PeekLine()
+	pos := pc.BlockOffset()
+	if pos < 0 || !bytes.HasPrefix(line[pos:], []byte("$$")) {
+		return nil, parser.NoChildren
+	}
+	node := &mathBlock{open: segment.Start + pos, close: -1}
+	rest := bytes.TrimRight(line[pos+2:], " \t\r\n")
+	if len(rest) == 0 {
+		return node, parser.NoChildren
+	}
+	// The whole block can be on one line, but only if nothing comes after its closing `$$`.
+	if len(rest) < 3 || !bytes.HasSuffix(rest, []byte("$$")) {
+		return nil, parser.NoChildren
+	}
+	start := segment.Start + pos + 2
+	node.Lines().Append(gmtext.NewSegment(start, start+len(rest)-2))
+	node.close, node.done = start+len(rest)-2, true
+	return node, parser.NoChildren
+}
+
+func (mathBlockParser) Continue(node ast.Node, reader gmtext.Reader, _ parser.Context) parser.State {
+	n := node.(*mathBlock)
+	if n.done {
+		return parser.Close
+	}
+	line, segment := reader.PeekLine()
+	if bytes.Equal(bytes.TrimSpace(line), []byte("$$")) {
+		n.close = segment.Start + bytes.IndexByte(line, '$')
+		newline := 1
+		if line[len(line)-1] != '\n' {
+			newline = 0
+		}
+		reader.Advance(segment.Len() - newline + segment.Padding)
+		return parser.Close
+	}
+	node.Lines().Append(segment)
+	reader.AdvanceAndSetPadding(segment.Len()-1, segment.Padding)
+	return parser.Continue | parser.NoChildren
+}
+
+func (mathBlockParser) Close(ast.Node, gmtext.Reader, parser.Context) {}
+
+func (mathBlockParser) CanInterruptParagraph() bool {
+	return true
+}
+
+func (mathBlockParser) CanAcceptIndentedLine() bool {
+	return false
+}
+
+type mathInlineParser struct{}
+
+func (mathInlineParser) Trigger() []byte {
+	return []byte{'$'}
+}
+
+// Parse parses math between `$` (or `$$`) on a single line. Like in Pandoc, the opening `$`
+// can't be followed by a space, and the closing one can't come after a space or before a
+// digit, so that prices aren't taken for math. The math also ends at the next `$` (that
+// isn't escaped), so if that one can't close it then there isn't any.
+func (mathInlineParser) Parse(_ ast.Node, block gmtext.Reader, _ parser.Context) ast.Node {
+	line, segment := block.PeekLine()
+	delim := 1
+	if len(line) > 1 && line[1] == '$' {
+		delim = 2
+	}
+	body := line[delim:]
+	if len(body) == 0 || util.IsSpace(body[0]) || body[0] == '$' {
+		return nil
+	}
+	for i := 1; i < len(body); i++ {
+		switch {
+		case body[i] == '\\':
+			i++
+			continue
+		case body[i] != '$':
+			continue
+		case delim == 2:
+			if i+1 == len(body) || body[i+1] != '$' {
+				return nil
+			}
+		case util.IsSpace(body[i-1]) || i+1 < len(body) && body[i+1] >= '0' && body[i+1] <= '9':
+			return nil
+		}
+		start := segment.Start + delim
+		block.Advance(delim + i + delim)
+		return &mathInline{Segment: gmtext.NewSegment(start, start+i), display: delim == 2}
+	}
+	return nil
+}
+
+// isMath is a block of math, which is laid out as a formula of its own.
+type isMath struct {
+	root texNode
+	err  error
+}
+
+func (sb *spanBuilder) renderMathBlock(_ util.BufWriter, src []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	var tex bytes.Buffer
+	for i := 0; i < node.Lines().Len(); i++ {
+		seg := node.Lines().At(i)
+		tex.Write(seg.Value(src))
+	}
+	source := string(bytes.TrimSpace(tex.Bytes()))
+	root, err := parseTeX(source)
+
+	style := *sb.currentSpan()
+	if sb.hasContent() {
+		sb.commitGroup()
+	}
+	// The source is kept to be shown in place of math that can't be laid out.
+	l := material.Body1(sb.theme, "")
+	l.Font.Variant = "Mono"
+	sb.current = spanGroup{mdata: isMath{root: root, err: err}}
+	sb.newSpan(l)
+	sb.currentSpan().Content = source
+	if err != nil {
+		l = material.Body2(sb.theme, "")
+		l.Color.A = 120
+		sb.newSpan(l)
+		sb.currentSpan().Content = "\n" + err.Error()
+	}
+	sb.commitGroup()
+	sb.current.items = append(sb.current.items, richtext.SpanStyle{
+		Font:  style.Font,
+		Size:  style.Size,
+		Color: style.Color,
+	})
+	return ast.WalkSkipChildren, nil
+}
+
+// renderMathInline renders math within text, which layFlow lays out the same way as a block
+// of math (but in the size of the text). Its span's text is the closest approximation to it
+// in plain text. Math that can't be parsed is shown as its source.
+func (sb *spanBuilder) renderMathInline(_ util.BufWriter, src []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*mathInline)
+	source := string(n.Segment.Value(src))
+	style := *sb.currentSpan()
+	math := style
+	root, err := parseTeX(source)
+	if err == nil {
+		math.Font.Style = text.Italic
+		math.Content = texText(root)
+	} else {
+		math.Font.Variant = "Mono"
+		math.Content = source
+	}
+	style.Content = ""
+	sb.current.items = append(sb.current.items, math)
+	if err == nil {
+		sb.flowSpan(len(sb.current.items) - 1).math = root
+	}
+	sb.current.items = append(sb.current.items, style)
+	return ast.WalkSkipChildren, nil
+}
+
+// layMath lays out a block of math centered within the width. Math that's wider than that
+// scrolls sideways, and math that can't be parsed is shown as its source.
+func layMath(gtx C, th *material.Theme, blk *spanGroup, st *blockState) D {
+	m := blk.mdata.(isMath)
+	if m.err != nil {
+		return richtext.Text(&st.text, th.Shaper, blk.items...).Layout(gtx)
+	}
+	l := texLayout{shaper: th.Shaper, color: th.Fg}
+	box := l.lay(gtx, m.root, th.TextSize*1.2)
+	width := gtx.Constraints.Max.X
+	if st.scroll.Axis != layout.Horizontal {
+		st.scroll.Axis = layout.Horizontal
+	}
+	return material.List(th, &st.scroll).Layout(gtx, 1, func(gtx C, _ int) D {
+		x := max(0, (width-box.width)/2)
+		box.place(gtx.Ops, x, 0)
+		return D{Size: image.Point{x + box.width, box.height()}, Baseline: box.descent}
+	})
+}
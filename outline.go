@@ -0,0 +1,230 @@
+package mdedit
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"gioui.org/text"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	"github.com/yuin/goldmark/ast"
+)
+
+// outlineEntry is a heading of a document.
+type outlineEntry struct {
+	level int
+	text  string
+	id    string // the heading's id, as in a link's `#fragment`
+	line  int    // the line of the source that the heading is on
+}
+
+// buildOutline returns the headings of a document. Their ids are the same as the ones that
+// the document gives them when it's rendered.
+func buildOutline(doc *mdParse) []outlineEntry {
+	var entries []outlineEntry
+	slugs := make(map[string]int)
+	off, line := 0, 0
+	_ = ast.Walk(doc.root, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		n, ok := node.(*ast.Heading)
+		if !entering || !ok {
+			return ast.WalkContinue, nil
+		}
+		txt := n.Text(doc.src)
+		id := uniqueSlug(slugs, txt)
+		if n.Lines().Len() == 0 {
+			return ast.WalkSkipChildren, nil
+		}
+		// The headings come in the order that they're in the source, so the lines only need
+		// to be counted from the last one.
+		start := n.Lines().At(0).Start
+		line += bytes.Count(doc.src[off:start], []byte{'\n'})
+		off = start
+		entries = append(entries, outlineEntry{
+			level: n.Level,
+			text:  string(txt),
+			id:    id,
+			line:  line,
+		})
+		return ast.WalkSkipChildren, nil
+	})
+	return entries
+}
+
+// outlineSection returns the index of the entry whose section the given line is in, or -1
+// if the line comes before all of the headings.
+func outlineSection(entries []outlineEntry, row int) int {
+	cur := -1
+	for i, e := range entries {
+		if e.line > row {
+			break
+		}
+		cur = i
+	}
+	return cur
+}
+
+// These are the lines that a table of contents is between.
+const (
+	tocStart = "<!-- toc -->"
+	tocStop  = "<!-- tocstop -->"
+)
+
+// tocLines returns the lines of a table of contents of the given headings, as a nested list
+// of links to them.
+func tocLines(entries []outlineEntry) [][]byte {
+	top := 0
+	for _, e := range entries {
+		if top == 0 || e.level < top {
+			top = e.level
+		}
+	}
+	escape := strings.NewReplacer(`[`, `\[`, `]`, `\]`)
+	lines := make([][]byte, 0, len(entries))
+	for _, e := range entries {
+		indent := strings.Repeat("  ", e.level-top)
+		lines = append(lines, []byte(fmt.Sprintf("%s- [%s](#%s)", indent, escape.Replace(e.text), e.id)))
+	}
+	return lines
+}
+
+// UpdateTOC is like updateTOC, but for outside of the editor's handling of key events.
+func (ed *Editor) UpdateTOC() {
+	numLines, row := len(ed.buf.lines), ed.buf.cursor.row
+	ed.updateTOC()
+	ed.updateFolds(numLines, row)
+}
+
+// updateTOC fills in the table of contents that starts with a `<!-- toc -->` line, replacing
+// whatever was there, or inserts a new one above the cursor's line if there isn't one.
+func (ed *Editor) updateTOC() {
+	numLines, row := len(ed.buf.lines), ed.buf.cursor.row
+	entries := buildOutline(parseMarkdown(ed.Text()))
+	start, stop := -1, -1
+	for i := range ed.buf.lines {
+		switch s := string(bytes.TrimSpace(ed.buf.lines[i].text)); {
+		case start == -1 && s == tocStart:
+			start = i
+		case start != -1 && s == tocStop:
+			stop = i
+		}
+		if stop != -1 {
+			break
+		}
+	}
+	toc := tocLines(entries)
+	switch {
+	case start == -1:
+		start = row
+		ed.buf.insertLines(row, append(append([][]byte{[]byte(tocStart)}, toc...), []byte(tocStop))...)
+	case stop == -1:
+		ed.buf.insertLines(start+1, append(toc, []byte(tocStop))...)
+	default:
+		ed.buf.lines = append(ed.buf.lines[:start+1], ed.buf.lines[stop:]...)
+		ed.buf.insertLines(start+1, toc...)
+	}
+	// The cursor stays on its line if that's below the table of contents.
+	if row >= start {
+		ed.buf.cursor.row = min(max(start, row+len(ed.buf.lines)-numLines), len(ed.buf.lines)-1)
+		ed.buf.clampCol(true)
+	}
+	ed.highlight()
+	ed.changed = true
+}
+
+// outlinePane lays out a document's headings, each of which can be clicked to jump to it.
+type outlinePane struct {
+	entries   []outlineEntry
+	clicks    []widget.Clickable // one for each entry
+	list      widget.List
+	updateTOC widget.Clickable
+}
+
+func (p *outlinePane) set(entries []outlineEntry) {
+	p.entries = entries
+	if n := len(entries) - len(p.clicks); n > 0 {
+		p.clicks = append(p.clicks, make([]widget.Clickable, n)...)
+	}
+}
+
+// clicked returns the entry that was clicked, if one was.
+func (p *outlinePane) clicked() (outlineEntry, bool) {
+	for i := range p.entries {
+		if p.clicks[i].Clicked() {
+			return p.entries[i], true
+		}
+	}
+	return outlineEntry{}, false
+}
+
+// layout lays out the outline with the entry at the given index (the current section)
+// highlighted.
+func (p *outlinePane) layout(gtx C, th *material.Theme, current int) D {
+	if p.list.Axis != layout.Vertical {
+		p.list.Axis = layout.Vertical
+	}
+	return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+		layout.Rigid(func(gtx C) D {
+			return layout.UniformInset(8).Layout(gtx, func(gtx C) D {
+				return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+					layout.Flexed(1, headerLbl(th, "Outline")),
+					layout.Rigid(func(gtx C) D {
+						return buttonGroup{
+							bg:       merge(th.Bg, th.Fg, 0.08),
+							fg:       th.Fg,
+							shaper:   th.Shaper,
+							textSize: th.TextSize * 0.8,
+						}.layout(gtx, []groupButton{
+							{click: &p.updateTOC, text: "Update TOC"},
+						})
+					}),
+				)
+			})
+		}),
+		layout.Flexed(1, func(gtx C) D {
+			if len(p.entries) == 0 {
+				return layout.UniformInset(8).Layout(gtx, func(gtx C) D {
+					l := material.Body2(th, "No headings")
+					l.Color.A /= 2
+					return l.Layout(gtx)
+				})
+			}
+			return material.List(th, &p.list).Layout(gtx, len(p.entries), func(gtx C, i int) D {
+				return p.layEntry(gtx, th, i, i == current)
+			})
+		}),
+	)
+}
+
+func (p *outlinePane) layEntry(gtx C, th *material.Theme, i int, current bool) D {
+	e := p.entries[i]
+	m := op.Record(gtx.Ops)
+	gtx.Constraints.Min.X = gtx.Constraints.Max.X
+	inset := layout.Inset{Top: 3, Bottom: 3, Left: unit.Dp(8 + 12*(e.level-1)), Right: 8}
+	dims := inset.Layout(gtx, func(gtx C) D {
+		l := material.Body2(th, e.text)
+		l.MaxLines = 1
+		if current {
+			l.Font.Weight = text.Bold
+		} else if e.level > 2 {
+			l.Color.A = 200
+		}
+		return l.Layout(gtx)
+	})
+	call := m.Stop()
+	switch {
+	case current:
+		paint.FillShape(gtx.Ops, merge(th.Bg, th.ContrastBg, 0.25), clip.Rect{Max: dims.Size}.Op())
+	case p.clicks[i].Hovered():
+		paint.FillShape(gtx.Ops, merge(th.Bg, th.Fg, 0.06), clip.Rect{Max: dims.Size}.Op())
+	}
+	return p.clicks[i].Layout(gtx, func(gtx C) D {
+		call.Add(gtx.Ops)
+		return dims
+	})
+}
@@ -0,0 +1,96 @@
+package mdedit
+
+import (
+	"sync"
+	"time"
+
+	"gioui.org/widget/material"
+)
+
+// renderDelay is how long the renderWorker waits for more edits before it renders, so that a
+// burst of typing is only parsed and rendered once.
+const renderDelay = 60 * time.Millisecond
+
+// renderWorker parses and renders a document in the background, so that doing so for a big
+// one doesn't hold up the frame that it changed in. Texts that come in while it's busy are
+// coalesced, and only the latest is rendered.
+type renderWorker struct {
+	invalidate func() // called whenever a render has finished
+	renderer   *docRenderer
+
+	mu      sync.Mutex
+	pending *renderJob    // the next text to render, if there is one
+	busy    bool          // whether the worker's goroutine is running
+	done    *renderResult // the latest render that's yet to be taken
+}
+
+type renderJob struct {
+	src []byte
+	th  *material.Theme
+}
+
+type renderResult struct {
+	elements []spanGroup
+	outline  []outlineEntry
+	err      error
+	// lines and marks are the lines of the text and their style marks for the editor, as
+	// returned by parsedMarks.
+	lines [][]byte
+	marks [][]mdStyleMark
+}
+
+// request has the given text rendered in place of any that's still waiting to be. The
+// worker keeps it, so it mustn't be modified afterwards.
+func (w *renderWorker) request(src []byte, th *material.Theme) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pending = &renderJob{src: src, th: th}
+	if !w.busy {
+		w.busy = true
+		go w.run()
+	}
+}
+
+// run renders the pending texts until there aren't any more. Only one runs at a time, so
+// it has the renderer to itself.
+func (w *renderWorker) run() {
+	if w.renderer == nil {
+		w.renderer = newDocRenderer()
+	}
+	for {
+		time.Sleep(renderDelay)
+		w.mu.Lock()
+		job := w.pending
+		w.pending = nil
+		if job == nil {
+			w.busy = false
+			w.mu.Unlock()
+			return
+		}
+		w.mu.Unlock()
+
+		doc := parseMarkdown(job.src)
+		var res renderResult
+		res.elements, res.err = w.renderer.Render(job.th, doc)
+		res.outline = buildOutline(doc)
+		res.lines, res.marks = parsedMarks(job.src, doc)
+		w.mu.Lock()
+		w.done = &res
+		w.mu.Unlock()
+		if w.invalidate != nil {
+			w.invalidate()
+		}
+	}
+}
+
+// take returns the latest finished render if there is one that hasn't been taken yet.
+func (w *renderWorker) take() (renderResult, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	res := w.done
+	w.done = nil
+	if res == nil {
+		return renderResult{}, false
+	}
+	return *res, true
+}
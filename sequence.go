@@ -0,0 +1,290 @@
+package mdedit
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+
+	"gioui.org/f32"
+	"gioui.org/op"
+	"gioui.org/widget/material"
+)
+
+// seqDiagram is a sequence diagram in the syntax of js-sequence-diagrams:
+//
+//	Title: Logging in
+//	participant Browser
+//	Browser->Server: POST /login
+//	Note right of Server: checks the password
+//	Server-->Browser: 200 OK
+//
+// A -> is a solid line, a --> is a dashed one, and ->> and -->> have open arrowheads.
+type seqDiagram struct {
+	title        string
+	participants []string
+	steps        []seqStep
+}
+
+// seqNoteColor is the color within notes.
+var seqNoteColor = color.NRGBA{230, 200, 90, 40}
+
+// seqStep is either a message or a note (if note isn't "").
+type seqStep struct {
+	from, to int // participants (for a note over more than one, from the leftmost to the rightmost)
+	text     string
+	dashed   bool
+	open     bool
+	note     string // "left", "right" or "over"
+}
+
+// parseSequence parses a sequence diagram.
+func parseSequence(src string) (diagram, error) {
+	d := &seqDiagram{}
+	ids := make(map[string]int)
+	participant := func(name string) int {
+		name = strings.Trim(strings.TrimSpace(name), `"`)
+		if i, ok := ids[name]; ok {
+			return i
+		}
+		ids[name] = len(d.participants)
+		d.participants = append(d.participants, name)
+		return ids[name]
+	}
+	for i, ln := range strings.Split(src, "\n") {
+		ln = strings.TrimSpace(ln)
+		lower := strings.ToLower(ln)
+		switch {
+		case ln == "" || strings.HasPrefix(ln, "#"):
+		case strings.HasPrefix(lower, "title:"):
+			d.title = diagramLabel(strings.TrimSpace(ln[len("title:"):]))
+		case strings.HasPrefix(lower, "participant "):
+			name := strings.TrimSpace(ln[len("participant "):])
+			// With "participant Name as A", the participant is called A in the rest of it.
+			if j := strings.Index(strings.ToLower(name), " as "); j != -1 {
+				alias := strings.TrimSpace(name[j+len(" as "):])
+				ids[alias] = participant(name[:j])
+			} else {
+				participant(name)
+			}
+		case strings.HasPrefix(lower, "note "):
+			step, err := parseSeqNote(ln[len("note "):], participant)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+			d.steps = append(d.steps, step)
+		default:
+			step, ok := parseSeqMessage(ln, participant)
+			if !ok {
+				return nil, fmt.Errorf("line %d: expected a message like 'A->B: text'", i+1)
+			}
+			d.steps = append(d.steps, step)
+		}
+	}
+	if len(d.participants) == 0 {
+		return nil, fmt.Errorf("no participants")
+	}
+	return d, nil
+}
+
+func parseSeqMessage(ln string, participant func(string) int) (seqStep, bool) {
+	var step seqStep
+	head, text, _ := strings.Cut(ln, ":")
+	i := strings.Index(head, "->")
+	if i == -1 {
+		return step, false
+	}
+	from, to := head[:i], head[i+2:]
+	if strings.HasSuffix(from, "-") {
+		from, step.dashed = from[:len(from)-1], true
+	}
+	if strings.HasPrefix(to, ">") {
+		to, step.open = to[1:], true
+	}
+	if strings.TrimSpace(from) == "" || strings.TrimSpace(to) == "" {
+		return step, false
+	}
+	step.from, step.to = participant(from), participant(to)
+	step.text = diagramLabel(strings.TrimSpace(text))
+	return step, true
+}
+
+func parseSeqNote(ln string, participant func(string) int) (seqStep, error) {
+	var step seqStep
+	where, text, ok := strings.Cut(ln, ":")
+	if !ok {
+		return step, fmt.Errorf("expected a note like 'Note over A: text'")
+	}
+	step.text = diagramLabel(strings.TrimSpace(text))
+	lower := strings.ToLower(where)
+	var names string
+	switch {
+	case strings.HasPrefix(lower, "left of "):
+		step.note, names = "left", where[len("left of "):]
+	case strings.HasPrefix(lower, "right of "):
+		step.note, names = "right", where[len("right of "):]
+	case strings.HasPrefix(lower, "over "):
+		step.note, names = "over", where[len("over "):]
+	default:
+		return step, fmt.Errorf("expected 'left of', 'right of' or 'over' after 'Note'")
+	}
+	first, last, _ := strings.Cut(names, ",")
+	step.from = participant(first)
+	step.to = step.from
+	if last != "" {
+		step.to = participant(last)
+	}
+	if step.from > step.to {
+		step.from, step.to = step.to, step.from
+	}
+	return step, nil
+}
+
+// layout lays out the participants in a row with their lifelines going down from them, and
+// each step below the one before it. The participants are far enough apart for the text of
+// the messages between them.
+func (d *seqDiagram) layout(gtx C, th *material.Theme) D {
+	pen := newDiagramPen(th)
+	pad := image.Point{gtx.Dp(12), gtx.Dp(7)}
+	gap, loop := gtx.Dp(24), gtx.Dp(28)
+
+	boxes := make([]diagramText, len(d.participants))
+	boxSize := make([]image.Point, len(d.participants))
+	var boxHeight int
+	for i, p := range d.participants {
+		boxes[i] = pen.text(gtx, p)
+		boxSize[i] = boxes[i].size.Add(pad.Mul(2))
+		boxHeight = max(boxHeight, boxSize[i].Y)
+	}
+	texts := make([]diagramText, len(d.steps))
+	for i, s := range d.steps {
+		texts[i] = pen.text(gtx, s.text)
+	}
+
+	// Space the lifelines out, first for the participants' boxes and then for the steps.
+	xs := make([]int, len(d.participants))
+	for i := range xs {
+		if i > 0 {
+			xs[i] = xs[i-1] + (boxSize[i-1].X+boxSize[i].X)/2 + gap
+		}
+	}
+	spread := func(from, to, need int) {
+		if short := need - (xs[to] - xs[from]); short > 0 {
+			for i := to; i < len(xs); i++ {
+				xs[i] += short
+			}
+		}
+	}
+	for i, s := range d.steps {
+		w := texts[i].size.X
+		switch {
+		case s.note == "over" && s.from != s.to:
+			spread(s.from, s.to, w-2*pad.X)
+		case s.note == "right" && s.to+1 < len(xs):
+			spread(s.to, s.to+1, w+2*pad.X+gap/2+boxSize[s.to+1].X/2)
+		case s.note == "left" && s.from > 0:
+			spread(s.from-1, s.from, w+2*pad.X+gap/2+boxSize[s.from-1].X/2)
+		case s.note != "":
+		case s.from == s.to && s.from+1 < len(xs):
+			spread(s.from, s.from+1, loop+w+gap)
+		case s.from != s.to:
+			a, b := s.from, s.to
+			if a > b {
+				a, b = b, a
+			}
+			spread(a, b, w+gap)
+		}
+	}
+
+	// Find how far the diagram goes to either side, as notes and loops can stick out.
+	left, right := -boxSize[0].X/2, xs[len(xs)-1]+boxSize[len(xs)-1].X/2
+	noteRect := func(i int) image.Rectangle {
+		s, size := d.steps[i], texts[i].size.Add(pad.Mul(2))
+		var x int
+		switch s.note {
+		case "left":
+			x = xs[s.from] - gap/4 - size.X
+		case "right":
+			x = xs[s.to] + gap/4
+		default:
+			mid := (xs[s.from] + xs[s.to]) / 2
+			size.X = max(size.X, xs[s.to]-xs[s.from]+2*pad.X)
+			x = mid - size.X/2
+		}
+		return image.Rectangle{Min: image.Point{x, 0}, Max: image.Point{x + size.X, size.Y}}
+	}
+	for i, s := range d.steps {
+		switch {
+		case s.note != "":
+			r := noteRect(i)
+			left, right = min(left, r.Min.X), max(right, r.Max.X)
+		case s.from == s.to:
+			right = max(right, xs[s.from]+loop+gap/4+texts[i].size.X)
+		}
+	}
+	margin := gtx.Dp(2)
+	for i := range xs {
+		xs[i] += margin - left
+	}
+	width := right - left + 2*margin
+
+	y := margin
+	if d.title != "" {
+		t := pen.text(gtx, d.title)
+		pen.drawText(gtx, t, image.Point{width / 2, y + t.size.Y/2}, false)
+		y += t.size.Y + gap/2
+	}
+	top := y
+	y += boxHeight + gap/2
+	rowGap := gtx.Dp(10)
+	// The lifelines go under the steps, but how long they are isn't known until after.
+	steps := op.Record(gtx.Ops)
+	for i, s := range d.steps {
+		t := texts[i]
+		switch {
+		case s.note != "":
+			r := noteRect(i).Add(image.Point{0, y})
+			pen.box(gtx, r, 0, seqNoteColor)
+			pen.drawText(gtx, t, r.Min.Add(r.Size().Div(2)), false)
+			y = r.Max.Y + rowGap
+		case s.from == s.to:
+			x := float32(xs[s.from])
+			out, back := float32(y+t.size.Y/2), float32(y+t.size.Y/2+gtx.Dp(14))
+			far := x + float32(loop)
+			pen.line(gtx, f32.Pt(x, out), f32.Pt(far, out), s.dashed)
+			pen.line(gtx, f32.Pt(far, out), f32.Pt(far, back), s.dashed)
+			pen.line(gtx, f32.Pt(far, back), f32.Pt(x, back), s.dashed)
+			pen.arrowHead(gtx, f32.Pt(x, back), f32.Pt(far, back), s.open)
+			pen.drawText(gtx, t, image.Point{xs[s.from] + loop + gap/4 + t.size.X/2, y + t.size.Y/2}, false)
+			y = int(back) + rowGap
+		default:
+			from, to := float32(xs[s.from]), float32(xs[s.to])
+			pen.drawText(gtx, t, image.Point{(xs[s.from] + xs[s.to]) / 2, y + t.size.Y/2}, false)
+			y += t.size.Y + gtx.Dp(4)
+			line := float32(y)
+			pen.line(gtx, f32.Pt(from, line), f32.Pt(to, line), s.dashed)
+			pen.arrowHead(gtx, f32.Pt(to, line), f32.Pt(from, line), s.open)
+			y += rowGap * 2
+		}
+	}
+	stepsCall := steps.Stop()
+	y += gap / 2
+	bottom := y
+
+	lifelines := op.Record(gtx.Ops)
+	for i := range d.participants {
+		x := float32(xs[i])
+		pen.line(gtx, f32.Pt(x, float32(top+boxHeight)), f32.Pt(x, float32(bottom)), true)
+		for _, by := range []int{top, bottom} {
+			r := image.Rectangle{
+				Min: image.Point{xs[i] - boxSize[i].X/2, by},
+				Max: image.Point{xs[i] + boxSize[i].X/2, by + boxHeight},
+			}
+			pen.box(gtx, r, gtx.Dp(4), pen.fill)
+			pen.drawText(gtx, boxes[i], r.Min.Add(r.Size().Div(2)), false)
+		}
+	}
+	lifelines.Stop().Add(gtx.Ops)
+	stepsCall.Add(gtx.Ops)
+	return D{Size: image.Point{width, bottom + boxHeight + margin}}
+}
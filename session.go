@@ -2,6 +2,7 @@ package mdedit
 
 import (
 	"errors"
+	"fmt"
 	"image"
 	"image/color"
 	"io/fs"
@@ -37,6 +38,7 @@ type Session struct {
 	tabs      []tab
 	tabList   layout.List
 	activeTab int
+	images    *imageCache
 }
 
 type tab struct {
@@ -49,6 +51,7 @@ func NewSession(fsys FS, win *app.Window) Session {
 		fsys:    fsys,
 		win:     win,
 		tabList: layout.List{Axis: layout.Vertical},
+		images:  newImageCache(fsys, win.Invalidate),
 	}
 }
 
@@ -150,17 +153,35 @@ func (s *Session) layMarkdownTab(gtx C, th *material.Theme, t *markdownTab) D {
 	if t.view.Editor.SaveRequested() {
 		go s.writeFile(t.name, t.view.Editor.Text())
 	}
+	for _, dest := range t.view.document.Links() {
+		s.followLink(t, dest)
+		op.InvalidateOp{}.Add(gtx.Ops)
+	}
 	return ViewStyle{
 		Theme:      th,
 		EditorFont: text.Font{Variant: "Mono"},
 		Palette: Palette{
-			Fg:         th.Fg,
-			Bg:         th.Bg,
-			LineNumber: color.NRGBA{200, 180, 4, 125},
-			Heading:    color.NRGBA{200, 193, 255, 255},
-			ListMarker: color.NRGBA{10, 190, 240, 255},
-			BlockQuote: color.NRGBA{165, 165, 165, 230},
-			CodeBlock:  color.NRGBA{162, 120, 70, 255},
+			Fg:            th.Fg,
+			Bg:            th.Bg,
+			LineNumber:    color.NRGBA{200, 180, 4, 125},
+			Heading:       color.NRGBA{200, 193, 255, 255},
+			ListMarker:    color.NRGBA{10, 190, 240, 255},
+			BlockQuote:    color.NRGBA{165, 165, 165, 230},
+			CodeBlock:     color.NRGBA{162, 120, 70, 255},
+			CodeKeyword:   color.NRGBA{200, 120, 190, 255},
+			CodeString:    color.NRGBA{150, 190, 110, 255},
+			CodeComment:   color.NRGBA{130, 130, 130, 255},
+			CodeNumber:    color.NRGBA{220, 160, 90, 255},
+			Math:          color.NRGBA{110, 190, 200, 255},
+			LinkText:      color.NRGBA{120, 200, 150, 255},
+			LinkURL:       color.NRGBA{110, 140, 190, 200},
+			Table:         color.NRGBA{130, 130, 160, 255},
+			Strikethrough: color.NRGBA{150, 150, 150, 200},
+			Task:          color.NRGBA{230, 170, 60, 255},
+			TaskDone:      color.NRGBA{120, 170, 110, 200},
+			Footnote:      color.NRGBA{180, 140, 210, 255},
+			FrontMatter:   color.NRGBA{140, 160, 200, 220},
+			Selection:     color.NRGBA{80, 90, 110, 160},
 		},
 		View: &t.view,
 	}.Layout(gtx)
@@ -194,29 +215,37 @@ func (s *Session) OpenFileExplorerTab() {
 }
 
 func (s *Session) OpenFile(fpath string) {
+	if err := s.openFile(fpath); err != nil {
+		log.Println(err)
+	}
+}
+
+// openFile opens the given file in a new tab, which is the last one unless there's an error.
+func (s *Session) openFile(fpath string) error {
 	if fpath == "" {
-		log.Println("open file: empty file path")
-		return
+		return errors.New("open file: empty file path")
 	}
 	data, err := s.fsys.ReadFile(fpath)
 	if err != nil && !errors.Is(err, fs.ErrNotExist) {
-		log.Printf("reading '%s': %v\n", fpath, err)
-		return
+		return fmt.Errorf("reading '%s': %w", fpath, err)
 	}
 	name := fpath
 	if fpath[0] == '/' {
 		rel, err := filepath.Rel(s.fsys.WorkingDir(), fpath)
 		if err != nil {
-			log.Printf("getting relative path '%s': %v\n", fpath, err)
-			return
+			return fmt.Errorf("getting relative path '%s': %w", fpath, err)
 		}
 		name = rel
 	}
 	md := &markdownTab{name: name}
+	md.view.Editor.AutoPairs = AutoPairAll
 	md.view.Editor.SetText(data)
 	md.view.SplitRatio = 0.5
+	md.view.document.useImages(s.images, path.Dir(name))
+	md.view.useInvalidate(s.win.Invalidate)
 	s.tabs = append(s.tabs, tab{content: md})
 	s.win.Invalidate()
+	return nil
 }
 
 func (s *Session) openExplorerDir(t *explorerTab, fpath string) {
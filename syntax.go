@@ -0,0 +1,279 @@
+package mdedit
+
+import (
+	"bytes"
+	"strings"
+)
+
+// tokenKind is the kind of a token within a line of source code in a fenced code block.
+type tokenKind uint8
+
+const (
+	tokenPlain tokenKind = iota
+	tokenKeyword
+	tokenString
+	tokenComment
+	tokenNumber
+)
+
+type codeToken struct {
+	start int
+	end   int
+	kind  tokenKind
+}
+
+// tokState is what the tokenizer carries over from one line to the next.
+type tokState struct {
+	comment bool   // within a block comment
+	quote   string // the delimiter of the multiline string that it's within (if any)
+}
+
+// codeLang describes just enough of a language's syntax to highlight its keywords, strings,
+// comments and numbers.
+type codeLang struct {
+	keywords     map[string]bool
+	ignoreCase   bool     // whether keywords are case insensitive
+	lineComments []string // prefixes that start a comment running to the end of the line
+	blockComment [2]string
+	quotes       string   // characters that start and end single line strings
+	multiQuotes  []string // delimiters of strings that can span multiple lines
+	keySep       byte     // if set, the first word on a line that's followed by it is a key
+}
+
+func keywordSet(s string) map[string]bool {
+	m := make(map[string]bool)
+	for _, w := range strings.Fields(s) {
+		m[w] = true
+	}
+	return m
+}
+
+var (
+	langGo = &codeLang{
+		keywords: keywordSet(`break case chan const continue default defer else fallthrough for func go
+			goto if import interface map package range return select struct switch type var
+			true false nil iota`),
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       `"'`,
+		multiQuotes:  []string{"`"},
+	}
+	langShell = &codeLang{
+		keywords: keywordSet(`if then else elif fi case esac for while until do done in function
+			return exit local export readonly shift break continue set unset source echo`),
+		lineComments: []string{"#"},
+		quotes:       `"'`,
+	}
+	langJSON = &codeLang{
+		keywords: keywordSet(`true false null`),
+		quotes:   `"`,
+	}
+	langYAML = &codeLang{
+		keywords:     keywordSet(`true false null yes no on off`),
+		lineComments: []string{"#"},
+		quotes:       `"'`,
+		keySep:       ':',
+	}
+	langTOML = &codeLang{
+		keywords:     keywordSet(`true false`),
+		lineComments: []string{"#"},
+		quotes:       `"'`,
+		multiQuotes:  []string{`"""`, "'''"},
+		keySep:       '=',
+	}
+	langPython = &codeLang{
+		keywords: keywordSet(`and as assert async await break class continue def del elif else except
+			finally for from global if import in is lambda nonlocal not or pass raise return try
+			while with yield True False None self`),
+		lineComments: []string{"#"},
+		quotes:       `"'`,
+		multiQuotes:  []string{`"""`, `'''`},
+	}
+	langJavaScript = &codeLang{
+		keywords: keywordSet(`async await break case catch class const continue debugger default delete
+			do else export extends finally for from function if import in instanceof let new of
+			return super switch this throw try typeof var void while with yield true false null
+			undefined`),
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       `"'`,
+		multiQuotes:  []string{"`"},
+	}
+	langSQL = &codeLang{
+		keywords: keywordSet(`select from where and or not insert into values update set delete create
+			table drop alter add index view join inner left right outer full on as group by order
+			having limit offset distinct union all null is in like between case when then else
+			end primary key foreign references default unique exists asc desc count sum avg min
+			max int integer text varchar boolean date timestamp`),
+		ignoreCase:   true,
+		lineComments: []string{"--"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       `'"`,
+	}
+	// langFallback is used for any languages that aren't known, and only highlights strings
+	// and numbers.
+	langFallback = &codeLang{
+		quotes: `"'`,
+	}
+)
+
+// codeLangFor returns the language named by the given fence info string (such as `go` or
+// `python {.class}`), falling back to a generic one for unknown languages. It returns nil if
+// the info string doesn't name a language at all.
+func codeLangFor(info []byte) *codeLang {
+	info = bytes.TrimSpace(info)
+	if i := bytes.IndexAny(info, " \t{,"); i != -1 {
+		info = info[:i]
+	}
+	if len(info) == 0 {
+		return nil
+	}
+	switch strings.ToLower(string(info)) {
+	case "go", "golang":
+		return langGo
+	case "sh", "bash", "shell", "zsh", "console":
+		return langShell
+	case "json", "jsonc":
+		return langJSON
+	case "yaml", "yml":
+		return langYAML
+	case "toml":
+		return langTOML
+	case "python", "py":
+		return langPython
+	case "javascript", "js", "jsx", "typescript", "ts", "tsx":
+		return langJavaScript
+	case "sql":
+		return langSQL
+	}
+	return langFallback
+}
+
+// tokenize returns the tokens (other than plain ones) within the given line of code. The
+// state is updated for the next line.
+func (lang *codeLang) tokenize(line []byte, st *tokState) (toks []codeToken) {
+	i := 0
+	// Finish off any comment or string that started on a previous line.
+	switch {
+	case st.comment:
+		i = lang.closeAfter(line, 0, lang.blockComment[1])
+		st.comment = i == -1
+		if i == -1 {
+			return append(toks, codeToken{0, len(line), tokenComment})
+		}
+		toks = append(toks, codeToken{0, i, tokenComment})
+	case st.quote != "":
+		i = lang.closeAfter(line, 0, st.quote)
+		if i == -1 {
+			return append(toks, codeToken{0, len(line), tokenString})
+		}
+		st.quote = ""
+		toks = append(toks, codeToken{0, i, tokenString})
+	}
+	for i < len(line) {
+		c := line[i]
+		if tok, ok := lang.comment(line, i, st); ok {
+			toks = append(toks, tok)
+			i = tok.end
+			continue
+		}
+		if tok, ok := lang.multilineString(line, i, st); ok {
+			toks = append(toks, tok)
+			i = tok.end
+			continue
+		}
+		switch {
+		case strings.IndexByte(lang.quotes, c) != -1:
+			end := lang.closeAfter(line, i+1, string(c))
+			if end == -1 {
+				end = len(line)
+			}
+			toks = append(toks, codeToken{i, end, tokenString})
+			i = end
+		case isDigit(c) && (i == 0 || !isWordChar(line[i-1])):
+			end := i + 1
+			for end < len(line) && (isWordChar(line[end]) || line[end] == '.') {
+				end++
+			}
+			toks = append(toks, codeToken{i, end, tokenNumber})
+			i = end
+		case isWordChar(c):
+			end := i + 1
+			for end < len(line) && (isWordChar(line[end]) || line[end] == '-' && lang.keySep != 0) {
+				end++
+			}
+			w := string(line[i:end])
+			if lang.ignoreCase {
+				w = strings.ToLower(w)
+			}
+			// A key is the first word on its line (or after a YAML sequence's dash).
+			sep := end
+			for sep < len(line) && line[sep] == ' ' {
+				sep++
+			}
+			isKey := lang.keySep != 0 && sep < len(line) && line[sep] == lang.keySep &&
+				len(bytes.TrimLeft(line[:i], " \t-")) == 0
+			if lang.keywords[w] || isKey {
+				toks = append(toks, codeToken{i, end, tokenKeyword})
+			}
+			i = end
+		default:
+			i++
+		}
+	}
+	return toks
+}
+
+// comment returns the comment that starts at the given index (if any). If a block comment
+// isn't closed on the same line, the state is updated to reflect that.
+func (lang *codeLang) comment(line []byte, i int, st *tokState) (codeToken, bool) {
+	rest := line[i:]
+	for _, pfx := range lang.lineComments {
+		// A shell comment has to be at the start of a word.
+		if bytes.HasPrefix(rest, []byte(pfx)) && (pfx != "#" || i == 0 || isSpace(line[i-1])) {
+			return codeToken{i, len(line), tokenComment}, true
+		}
+	}
+	if open := lang.blockComment[0]; open != "" && bytes.HasPrefix(rest, []byte(open)) {
+		end := lang.closeAfter(line, i+len(open), lang.blockComment[1])
+		if end == -1 {
+			st.comment = true
+			end = len(line)
+		}
+		return codeToken{i, end, tokenComment}, true
+	}
+	return codeToken{}, false
+}
+
+// multilineString returns the string that starts at the given index if it's one that can
+// span multiple lines. If it isn't closed on the same line, the state is updated to reflect
+// that.
+func (lang *codeLang) multilineString(line []byte, i int, st *tokState) (codeToken, bool) {
+	for _, q := range lang.multiQuotes {
+		if !bytes.HasPrefix(line[i:], []byte(q)) {
+			continue
+		}
+		end := lang.closeAfter(line, i+len(q), q)
+		if end == -1 {
+			st.quote = q
+			end = len(line)
+		}
+		return codeToken{i, end, tokenString}, true
+	}
+	return codeToken{}, false
+}
+
+// closeAfter returns the index right after the first unescaped occurrence of the given
+// delimiter at or after index i, or -1 if there isn't one.
+func (lang *codeLang) closeAfter(line []byte, i int, delim string) int {
+	for ; i < len(line); i++ {
+		if line[i] == '\\' && delim != "`" {
+			i++
+			continue
+		}
+		if bytes.HasPrefix(line[i:], []byte(delim)) {
+			return i + len(delim)
+		}
+	}
+	return -1
+}
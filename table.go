@@ -0,0 +1,154 @@
+package mdedit
+
+import (
+	"image"
+	"image/color"
+
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"gioui.org/text"
+	"gioui.org/widget/material"
+	"gioui.org/x/richtext"
+	"github.com/yuin/goldmark/ast"
+	extast "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/util"
+)
+
+// isTable is a GFM table, which is laid out as a grid of its cells.
+type isTable struct {
+	aligns []extast.Alignment
+	rows   [][]spanGroup // the cells of each row (the first is the header)
+}
+
+// renderTable puts a table into a group of its own. Like an image, it splits up the block
+// that it's in (such as a list item), which goes on after it.
+func (sb *spanBuilder) renderTable(_ util.BufWriter, _ []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		if sb.hasContent() {
+			sb.commitGroup()
+		}
+		sb.tableOuter = sb.current
+		sb.table = &isTable{aligns: node.(*extast.Table).Alignments}
+	} else {
+		sb.current = spanGroup{mdata: *sb.table}
+		sb.table = nil
+		sb.commitGroup()
+		sb.current, sb.tableOuter = sb.tableOuter, spanGroup{}
+	}
+	return ast.WalkContinue, nil
+}
+
+func (sb *spanBuilder) renderTableRow(_ util.BufWriter, _ []byte, _ ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		sb.table.rows = append(sb.table.rows, nil)
+	}
+	return ast.WalkContinue, nil
+}
+
+func (sb *spanBuilder) renderTableCell(_ util.BufWriter, _ []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		sb.current = spanGroup{}
+		l := material.Body1(sb.theme, "")
+		if node.Parent().Kind() == extast.KindTableHeader {
+			l.Font.Weight = text.Bold
+		}
+		sb.useStyle(l)
+	} else {
+		sb.finishFlow()
+		row := &sb.table.rows[len(sb.table.rows)-1]
+		*row = append(*row, sb.current)
+		sb.current = spanGroup{}
+	}
+	return ast.WalkContinue, nil
+}
+
+// layTable lays out a table with each column as wide as its widest cell. Cells aren't
+// wrapped, so a table that's wider than the document scrolls sideways.
+func (d *Document) layTable(gtx C, th *material.Theme, tbl isTable, st *blockState) D {
+	numCols, numCells := len(tbl.aligns), 0
+	for _, row := range tbl.rows {
+		numCols = max(numCols, len(row))
+		numCells += len(row)
+	}
+	if len(st.cells) != numCells {
+		st.cells = make([]richtext.InteractiveText, numCells)
+		st.flows = make([]flowState, numCells)
+	}
+
+	// Lay out each cell on its own first to find out how wide each column and how tall each
+	// row has to be.
+	pad := gtx.Dp(8)
+	cgtx := gtx
+	cgtx.Constraints = layout.Constraints{Max: image.Point{X: 1 << 24, Y: gtx.Constraints.Max.Y}}
+	calls := make([]op.CallOp, numCells)
+	sizes := make([]image.Point, numCells)
+	colWidths := make([]int, numCols)
+	rowHeights := make([]int, len(tbl.rows))
+	cell := 0
+	for i, row := range tbl.rows {
+		for j, c := range row {
+			m := op.Record(gtx.Ops)
+			sizes[cell] = d.layText(cgtx, th, c.items, c.flow, &st.cells[cell], &st.flows[cell]).Size
+			calls[cell] = m.Stop()
+			colWidths[j] = max(colWidths[j], sizes[cell].X+2*pad)
+			rowHeights[i] = max(rowHeights[i], sizes[cell].Y+2*pad)
+			cell++
+		}
+	}
+	var size image.Point
+	for _, w := range colWidths {
+		size.X += w
+	}
+	for _, h := range rowHeights {
+		size.Y += h
+	}
+
+	if st.scroll.Axis != layout.Horizontal {
+		st.scroll.Axis = layout.Horizontal
+	}
+	return material.List(th, &st.scroll).Layout(gtx, 1, func(gtx C, _ int) D {
+		border := color.NRGBA{120, 120, 120, 255}
+		if len(rowHeights) > 0 {
+			rect := clip.Rect{Max: image.Point{size.X, rowHeights[0]}}.Op()
+			paint.FillShape(gtx.Ops, color.NRGBA{190, 190, 190, 20}, rect)
+		}
+		cell, y := 0, 0
+		for i, row := range tbl.rows {
+			x := 0
+			for j := range row {
+				align := extast.AlignNone
+				if j < len(tbl.aligns) {
+					align = tbl.aligns[j]
+				}
+				off := image.Point{x + pad, y + pad}
+				switch space := colWidths[j] - 2*pad - sizes[cell].X; align {
+				case extast.AlignRight:
+					off.X += space
+				case extast.AlignCenter:
+					off.X += space / 2
+				}
+				stack := op.Offset(off).Push(gtx.Ops)
+				calls[cell].Add(gtx.Ops)
+				stack.Pop()
+				x += colWidths[j]
+				cell++
+			}
+			y += rowHeights[i]
+		}
+		// Draw the borders around each cell.
+		x, y := 0, 0
+		for _, w := range append([]int{0}, colWidths...) {
+			x += w
+			rect := clip.Rect{Min: image.Point{min(x, size.X-1), 0}, Max: image.Point{min(x, size.X-1) + 1, size.Y}}
+			paint.FillShape(gtx.Ops, border, rect.Op())
+		}
+		for _, h := range append([]int{0}, rowHeights...) {
+			y += h
+			rect := clip.Rect{Min: image.Point{0, min(y, size.Y-1)}, Max: image.Point{size.X, min(y, size.Y-1) + 1}}
+			paint.FillShape(gtx.Ops, border, rect.Op())
+		}
+		return D{Size: size}
+	})
+}
@@ -0,0 +1,1066 @@
+package mdedit
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"gioui.org/f32"
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"gioui.org/text"
+	"gioui.org/unit"
+	"gioui.org/widget"
+)
+
+// texNode is a part of a parsed TeX formula. It's one of the tex types below.
+type texNode interface{}
+
+// texRow is a sequence of nodes set one after the other, like the contents of a group.
+type texRow []texNode
+
+// texClass is what kind of symbol a texSym is, which decides the space around it.
+type texClass int
+
+const (
+	texOrd   texClass = iota // an ordinary symbol, like a variable or a digit
+	texBin                   // a binary operator, like +
+	texRel                   // a relation, like =
+	texPunct                 // punctuation, like a comma
+	texFunc                  // the name of a function, like sin
+	texLarge                 // a large operator, like a sum
+)
+
+type texSym struct {
+	text   string
+	class  texClass
+	italic bool
+	bold   bool
+	limits bool // whether the scripts go above and below rather than to the right
+}
+
+type texFrac struct {
+	num, den texRow
+	noBar    bool // as in a binomial coefficient
+}
+
+type texScripts struct {
+	base     texNode
+	sup, sub texRow // nil if there isn't one
+}
+
+type texSqrt struct {
+	body texRow
+}
+
+type texAccent struct {
+	mark      string // the mark that goes above, or "" for a rule (as in \overline)
+	combining string // the combining character for the mark in plain text
+	body      texRow
+}
+
+// texFenced is something between delimiters that are as tall as it is.
+type texFenced struct {
+	open, close string // "" for no delimiter
+	body        texNode
+}
+
+type texMatrix struct {
+	env  string
+	rows [][]texRow
+}
+
+type texSpace struct {
+	em float32
+}
+
+// parseTeX parses the subset of TeX math that the document can lay out.
+func parseTeX(src string) (texRow, error) {
+	p := &texParser{src: src}
+	row, err := p.row()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.src) {
+		return nil, fmt.Errorf("unexpected '%c'", p.src[p.pos])
+	}
+	return row, nil
+}
+
+type texParser struct {
+	src string
+	pos int
+}
+
+func (p *texParser) skipSpace() {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *texParser) expect(c byte) error {
+	p.skipSpace()
+	if p.pos >= len(p.src) || p.src[p.pos] != c {
+		return fmt.Errorf("missing '%c'", c)
+	}
+	p.pos++
+	return nil
+}
+
+// atStop reports whether the source continues with one of the given stops, which are
+// either characters or commands.
+func (p *texParser) atStop(stops []string) bool {
+	for _, s := range stops {
+		rest := p.src[p.pos:]
+		if !strings.HasPrefix(rest, s) {
+			continue
+		}
+		// A command doesn't stop at a longer one, like \right at \rightarrow.
+		if isASCIILetter(s[len(s)-1]) && len(rest) > len(s) && isASCIILetter(rest[len(s)]) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// row parses nodes up to the end of the source, a closing brace or one of the given stops.
+func (p *texParser) row(stops ...string) (texRow, error) {
+	row := texRow{}
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.src) || p.src[p.pos] == '}' || p.atStop(stops) {
+			return row, nil
+		}
+		switch c := p.src[p.pos]; c {
+		case '^', '_':
+			p.pos++
+			arg, err := p.arg()
+			if err != nil {
+				return nil, err
+			}
+			var sc texScripts
+			if n := len(row); n > 0 {
+				var ok bool
+				if sc, ok = row[n-1].(texScripts); !ok {
+					sc = texScripts{base: row[n-1]}
+				}
+				row = row[:n-1]
+			}
+			if c == '^' {
+				if sc.sup != nil {
+					return nil, errors.New("double superscript")
+				}
+				sc.sup = arg
+			} else {
+				if sc.sub != nil {
+					return nil, errors.New("double subscript")
+				}
+				sc.sub = arg
+			}
+			row = append(row, sc)
+		case '&':
+			return nil, errors.New("'&' outside of an environment")
+		default:
+			n, err := p.atom()
+			if err != nil {
+				return nil, err
+			}
+			if n != nil {
+				row = append(row, n)
+			}
+		}
+	}
+}
+
+// arg parses the argument of a command or a script, which is either a group in braces or a
+// single node.
+func (p *texParser) arg() (texRow, error) {
+	p.skipSpace()
+	if p.pos >= len(p.src) || p.src[p.pos] == '}' {
+		return nil, errors.New("missing argument")
+	}
+	n, err := p.atom()
+	if err != nil {
+		return nil, err
+	}
+	if row, ok := n.(texRow); ok {
+		return row, nil
+	}
+	return texRow{n}, nil
+}
+
+// braced returns the source of a group in braces as it is.
+func (p *texParser) braced() (string, error) {
+	if err := p.expect('{'); err != nil {
+		return "", err
+	}
+	start, depth := p.pos, 1
+	for ; p.pos < len(p.src); p.pos++ {
+		switch p.src[p.pos] {
+		case '{':
+			depth++
+		case '}':
+			if depth--; depth == 0 {
+				p.pos++
+				return p.src[start : p.pos-1], nil
+			}
+		}
+	}
+	return "", errors.New("missing '}'")
+}
+
+func (p *texParser) atom() (texNode, error) {
+	c := p.src[p.pos]
+	switch {
+	case c == '{':
+		p.pos++
+		row, err := p.row()
+		if err != nil {
+			return nil, err
+		}
+		return row, p.expect('}')
+	case c == '\\':
+		return p.command()
+	case isASCIILetter(c):
+		p.pos++
+		return texSym{text: string(c), italic: true}, nil
+	case c >= '0' && c <= '9' || c == '.':
+		// A number is set as one symbol.
+		start := p.pos
+		for p.pos < len(p.src) && (p.src[p.pos] >= '0' && p.src[p.pos] <= '9' || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		return texSym{text: p.src[start:p.pos]}, nil
+	}
+	r, n := utf8.DecodeRuneInString(p.src[p.pos:])
+	p.pos += n
+	if sym, ok := texChars[r]; ok {
+		return sym, nil
+	}
+	return texSym{text: string(r), italic: unicode.IsLetter(r)}, nil
+}
+
+func (p *texParser) command() (texNode, error) {
+	p.pos++ // the backslash
+	if p.pos >= len(p.src) {
+		return nil, errors.New("'\\' at the end")
+	}
+	start := p.pos
+	for p.pos < len(p.src) && isASCIILetter(p.src[p.pos]) {
+		p.pos++
+	}
+	if p.pos == start {
+		p.pos++
+	}
+	name := p.src[start:p.pos]
+
+	switch name {
+	case "frac", "dfrac", "tfrac", "binom":
+		num, err := p.arg()
+		if err != nil {
+			return nil, err
+		}
+		den, err := p.arg()
+		if err != nil {
+			return nil, err
+		}
+		if name == "binom" {
+			return texFenced{open: "(", close: ")", body: texFrac{num: num, den: den, noBar: true}}, nil
+		}
+		return texFrac{num: num, den: den}, nil
+	case "sqrt":
+		p.skipSpace()
+		if p.pos < len(p.src) && p.src[p.pos] == '[' {
+			// The index of a root other than the square root isn't shown.
+			p.pos++
+			if _, err := p.row("]"); err != nil {
+				return nil, err
+			}
+			if err := p.expect(']'); err != nil {
+				return nil, err
+			}
+		}
+		body, err := p.arg()
+		return texSqrt{body: body}, err
+	case "text", "textrm", "mathrm", "operatorname", "textit", "mathit", "textbf", "mathbf", "boldsymbol", "mathsf", "mathtt":
+		s, err := p.braced()
+		if err != nil {
+			return nil, err
+		}
+		sym := texSym{text: s}
+		switch name {
+		case "textit", "mathit":
+			sym.italic = true
+		case "textbf", "mathbf", "boldsymbol":
+			sym.bold = true
+		case "operatorname":
+			sym.class = texFunc
+		}
+		return sym, nil
+	case "mathbb":
+		s, err := p.braced()
+		return texSym{text: strings.Map(func(r rune) rune {
+			if bb, ok := texDoubleStruck[r]; ok {
+				return bb
+			}
+			return r
+		}, s)}, err
+	case "hat", "widehat", "bar", "overline", "vec", "dot", "ddot", "tilde", "widetilde":
+		body, err := p.arg()
+		if err != nil {
+			return nil, err
+		}
+		acc := texAccents[name]
+		acc.body = body
+		return acc, nil
+	case "left":
+		open, err := p.delimiter()
+		if err != nil {
+			return nil, err
+		}
+		body, err := p.row(`\right`)
+		if err != nil {
+			return nil, err
+		}
+		if !p.atStop([]string{`\right`}) {
+			return nil, errors.New(`\left without \right`)
+		}
+		p.pos += len(`\right`)
+		close, err := p.delimiter()
+		return texFenced{open: open, close: close, body: body}, err
+	case "right":
+		return nil, errors.New(`\right without \left`)
+	case "begin":
+		env, err := p.braced()
+		if err != nil {
+			return nil, err
+		}
+		return p.environment(env)
+	case "end":
+		return nil, errors.New(`\end without \begin`)
+	case "\\":
+		// A line break only means something within an environment.
+		return nil, nil
+	case ",", ":", ">", ";", " ", "!", "quad", "qquad":
+		return texSpaces[name], nil
+	case "{", "}", "$", "%", "&", "#", "_":
+		return texSym{text: name}, nil
+	}
+	if sym, ok := texSymbols[name]; ok {
+		return sym, nil
+	}
+	if limits, ok := texFunctions[name]; ok {
+		return texSym{text: name, class: texFunc, limits: limits}, nil
+	}
+	return nil, fmt.Errorf("unknown command \\%s", name)
+}
+
+// delimiter parses the delimiter after \left or \right.
+func (p *texParser) delimiter() (string, error) {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return "", errors.New("missing delimiter")
+	}
+	if p.src[p.pos] == '\\' {
+		n, err := p.command()
+		if sym, ok := n.(texSym); ok && err == nil && texDelimiters[sym.text] {
+			return sym.text, nil
+		}
+		return "", errors.New("unknown delimiter")
+	}
+	c := p.src[p.pos]
+	p.pos++
+	switch c {
+	case '.':
+		return "", nil
+	case '(', ')', '[', ']', '|':
+		return string(c), nil
+	}
+	return "", fmt.Errorf("unknown delimiter '%c'", c)
+}
+
+// environment parses the body of an environment up to its \end, where the cells of each row
+// are separated by & and the rows by \\.
+func (p *texParser) environment(env string) (texNode, error) {
+	var open, close string
+	switch env {
+	case "matrix", "smallmatrix", "aligned", "align", "align*", "gathered":
+	case "pmatrix":
+		open, close = "(", ")"
+	case "bmatrix":
+		open, close = "[", "]"
+	case "Bmatrix", "cases":
+		open, close = "{", "}"
+	case "vmatrix":
+		open, close = "|", "|"
+	case "Vmatrix":
+		open, close = "‖", "‖"
+	default:
+		return nil, fmt.Errorf("unknown environment '%s'", env)
+	}
+	if env == "cases" {
+		close = ""
+	}
+	m := texMatrix{env: env}
+	var cells []texRow
+	for {
+		cell, err := p.row("&", `\\`, `\end`)
+		if err != nil {
+			return nil, err
+		}
+		cells = append(cells, cell)
+		switch {
+		case p.atStop([]string{"&"}):
+			p.pos++
+		case p.atStop([]string{`\\`}):
+			p.pos += 2
+			m.rows, cells = append(m.rows, cells), nil
+		case p.atStop([]string{`\end`}):
+			p.pos += len(`\end`)
+			name, err := p.braced()
+			if err != nil {
+				return nil, err
+			}
+			if name != env {
+				return nil, fmt.Errorf(`\begin{%s} ended by \end{%s}`, env, name)
+			}
+			// A trailing \\ doesn't start another row.
+			if len(cells) > 1 || len(cells[0]) > 0 {
+				m.rows = append(m.rows, cells)
+			}
+			if open == "" && close == "" {
+				return m, nil
+			}
+			return texFenced{open: open, close: close, body: m}, nil
+		default:
+			return nil, fmt.Errorf(`missing \end{%s}`, env)
+		}
+	}
+}
+
+func isASCIILetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// texChars are the characters that are set differently from how they're typed.
+var texChars = map[rune]texSym{
+	'+':  {text: "+", class: texBin},
+	'-':  {text: "−", class: texBin},
+	'*':  {text: "∗", class: texBin},
+	'=':  {text: "=", class: texRel},
+	'<':  {text: "<", class: texRel},
+	'>':  {text: ">", class: texRel},
+	':':  {text: ":", class: texRel},
+	',':  {text: ",", class: texPunct},
+	';':  {text: ";", class: texPunct},
+	'\'': {text: "′"},
+}
+
+var texSymbols = map[string]texSym{
+	// greek letters
+	"alpha": {text: "α", italic: true}, "beta": {text: "β", italic: true},
+	"gamma": {text: "γ", italic: true}, "delta": {text: "δ", italic: true},
+	"epsilon": {text: "ϵ", italic: true}, "varepsilon": {text: "ε", italic: true},
+	"zeta": {text: "ζ", italic: true}, "eta": {text: "η", italic: true},
+	"theta": {text: "θ", italic: true}, "vartheta": {text: "ϑ", italic: true},
+	"iota": {text: "ι", italic: true}, "kappa": {text: "κ", italic: true},
+	"lambda": {text: "λ", italic: true}, "mu": {text: "μ", italic: true},
+	"nu": {text: "ν", italic: true}, "xi": {text: "ξ", italic: true},
+	"pi": {text: "π", italic: true}, "varpi": {text: "ϖ", italic: true},
+	"rho": {text: "ρ", italic: true}, "varrho": {text: "ϱ", italic: true},
+	"sigma": {text: "σ", italic: true}, "varsigma": {text: "ς", italic: true},
+	"tau": {text: "τ", italic: true}, "upsilon": {text: "υ", italic: true},
+	"phi": {text: "ϕ", italic: true}, "varphi": {text: "φ", italic: true},
+	"chi": {text: "χ", italic: true}, "psi": {text: "ψ", italic: true},
+	"omega": {text: "ω", italic: true},
+	"Gamma": {text: "Γ"}, "Delta": {text: "Δ"}, "Theta": {text: "Θ"}, "Lambda": {text: "Λ"},
+	"Xi": {text: "Ξ"}, "Pi": {text: "Π"}, "Sigma": {text: "Σ"}, "Upsilon": {text: "Υ"},
+	"Phi": {text: "Φ"}, "Psi": {text: "Ψ"}, "Omega": {text: "Ω"},
+	// binary operators
+	"pm": {text: "±", class: texBin}, "mp": {text: "∓", class: texBin},
+	"times": {text: "×", class: texBin}, "div": {text: "÷", class: texBin},
+	"cdot": {text: "·", class: texBin}, "ast": {text: "∗", class: texBin},
+	"star": {text: "⋆", class: texBin}, "circ": {text: "∘", class: texBin},
+	"bullet": {text: "•", class: texBin}, "oplus": {text: "⊕", class: texBin},
+	"otimes": {text: "⊗", class: texBin}, "cup": {text: "∪", class: texBin},
+	"cap": {text: "∩", class: texBin}, "setminus": {text: "∖", class: texBin},
+	"wedge": {text: "∧", class: texBin}, "land": {text: "∧", class: texBin},
+	"vee": {text: "∨", class: texBin}, "lor": {text: "∨", class: texBin},
+	// relations
+	"leq": {text: "≤", class: texRel}, "le": {text: "≤", class: texRel},
+	"geq": {text: "≥", class: texRel}, "ge": {text: "≥", class: texRel},
+	"neq": {text: "≠", class: texRel}, "ne": {text: "≠", class: texRel},
+	"approx": {text: "≈", class: texRel}, "equiv": {text: "≡", class: texRel},
+	"sim": {text: "∼", class: texRel}, "simeq": {text: "≃", class: texRel},
+	"cong": {text: "≅", class: texRel}, "propto": {text: "∝", class: texRel},
+	"ll": {text: "≪", class: texRel}, "gg": {text: "≫", class: texRel},
+	"in": {text: "∈", class: texRel}, "notin": {text: "∉", class: texRel},
+	"ni": {text: "∋", class: texRel}, "subset": {text: "⊂", class: texRel},
+	"supset": {text: "⊃", class: texRel}, "subseteq": {text: "⊆", class: texRel},
+	"supseteq": {text: "⊇", class: texRel}, "perp": {text: "⊥", class: texRel},
+	"mid": {text: "∣", class: texRel}, "parallel": {text: "∥", class: texRel},
+	"to": {text: "→", class: texRel}, "rightarrow": {text: "→", class: texRel},
+	"leftarrow": {text: "←", class: texRel}, "gets": {text: "←", class: texRel},
+	"leftrightarrow": {text: "↔", class: texRel}, "Rightarrow": {text: "⇒", class: texRel},
+	"Leftarrow": {text: "⇐", class: texRel}, "Leftrightarrow": {text: "⇔", class: texRel},
+	"iff": {text: "⇔", class: texRel}, "implies": {text: "⇒", class: texRel},
+	"mapsto": {text: "↦", class: texRel},
+	// large operators
+	"sum": {text: "∑", class: texLarge, limits: true}, "prod": {text: "∏", class: texLarge, limits: true},
+	"coprod": {text: "∐", class: texLarge, limits: true}, "bigcup": {text: "⋃", class: texLarge, limits: true},
+	"bigcap": {text: "⋂", class: texLarge, limits: true}, "int": {text: "∫", class: texLarge},
+	"iint": {text: "∬", class: texLarge}, "oint": {text: "∮", class: texLarge},
+	// everything else
+	"infty": {text: "∞"}, "partial": {text: "∂"}, "nabla": {text: "∇"},
+	"forall": {text: "∀"}, "exists": {text: "∃"}, "nexists": {text: "∄"},
+	"emptyset": {text: "∅"}, "varnothing": {text: "∅"}, "neg": {text: "¬"}, "lnot": {text: "¬"},
+	"angle": {text: "∠"}, "triangle": {text: "△"}, "hbar": {text: "ℏ"}, "ell": {text: "ℓ"},
+	"Re": {text: "ℜ"}, "Im": {text: "ℑ"}, "aleph": {text: "ℵ"}, "prime": {text: "′"},
+	"ldots": {text: "…"}, "dots": {text: "…"}, "cdots": {text: "⋯"}, "vdots": {text: "⋮"},
+	"ddots": {text: "⋱"}, "degree": {text: "°"},
+	"langle": {text: "⟨"}, "rangle": {text: "⟩"}, "lfloor": {text: "⌊"}, "rfloor": {text: "⌋"},
+	"lceil": {text: "⌈"}, "rceil": {text: "⌉"}, "|": {text: "‖"},
+	"lvert": {text: "|"}, "rvert": {text: "|"}, "lVert": {text: "‖"}, "rVert": {text: "‖"},
+}
+
+// texFunctions are the functions that are set upright, and whether their scripts are limits.
+var texFunctions = map[string]bool{
+	"sin": false, "cos": false, "tan": false, "cot": false, "sec": false, "csc": false,
+	"arcsin": false, "arccos": false, "arctan": false, "sinh": false, "cosh": false, "tanh": false,
+	"log": false, "ln": false, "lg": false, "exp": false, "det": false, "dim": false,
+	"ker": false, "deg": false, "arg": false, "gcd": false, "hom": false, "Pr": false,
+	"lim": true, "liminf": true, "limsup": true, "max": true, "min": true, "sup": true, "inf": true,
+}
+
+// texDelimiters are the delimiters that can be drawn at any height.
+var texDelimiters = map[string]bool{
+	"(": true, ")": true, "[": true, "]": true, "{": true, "}": true, "|": true, "‖": true,
+	"⟨": true, "⟩": true,
+}
+
+var texAccents = map[string]texAccent{
+	"hat":       {mark: "^", combining: "̂"},
+	"widehat":   {mark: "^", combining: "̂"},
+	"tilde":     {mark: "~", combining: "̃"},
+	"widetilde": {mark: "~", combining: "̃"},
+	"vec":       {mark: "→", combining: "⃗"},
+	"dot":       {mark: "˙", combining: "̇"},
+	"ddot":      {mark: "¨", combining: "̈"},
+	"bar":       {combining: "̄"},
+	"overline":  {combining: "̅"},
+}
+
+var texSpaces = map[string]texSpace{
+	",": {em: 0.17}, ":": {em: 0.22}, ">": {em: 0.22}, ";": {em: 0.28}, " ": {em: 0.33},
+	"!": {em: -0.17}, "quad": {em: 1}, "qquad": {em: 2},
+}
+
+var texDoubleStruck = map[rune]rune{
+	'C': 'ℂ', 'H': 'ℍ', 'N': 'ℕ', 'P': 'ℙ', 'Q': 'ℚ', 'R': 'ℝ', 'Z': 'ℤ',
+}
+
+// texText returns the closest plain text to a formula, for where it can't be laid out as
+// one (like within a line of text).
+func texText(n texNode) string {
+	var b strings.Builder
+	writeTeXText(&b, n)
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+func writeTeXText(b *strings.Builder, n texNode) {
+	switch n := n.(type) {
+	case texRow:
+		for _, c := range n {
+			writeTeXText(b, c)
+		}
+	case texSym:
+		switch n.class {
+		case texBin, texRel:
+			b.WriteString(" " + n.text + " ")
+		case texPunct, texFunc:
+			b.WriteString(n.text + " ")
+		default:
+			b.WriteString(n.text)
+		}
+	case texSpace:
+		if n.em > 0 {
+			b.WriteString(" ")
+		}
+	case texFrac:
+		sep := "/"
+		if n.noBar {
+			sep = " "
+		}
+		b.WriteString(texGroupText(n.num) + sep + texGroupText(n.den))
+	case texScripts:
+		b.WriteString(strings.TrimRight(texText(n.base), " "))
+		if n.sub != nil {
+			b.WriteString(texScriptText(n.sub, texSubscripts, "_"))
+		}
+		if n.sup != nil {
+			b.WriteString(texScriptText(n.sup, texSuperscripts, "^"))
+		}
+	case texSqrt:
+		b.WriteString("√" + texGroupText(n.body))
+	case texAccent:
+		s := texText(n.body)
+		if utf8.RuneCountInString(s) == 1 {
+			s += n.combining
+		}
+		b.WriteString(s)
+	case texFenced:
+		b.WriteString(n.open + texText(n.body) + n.close)
+	case texMatrix:
+		for i, row := range n.rows {
+			if i > 0 {
+				b.WriteString("; ")
+			}
+			for j, cell := range row {
+				if j > 0 {
+					b.WriteString(", ")
+				}
+				writeTeXText(b, cell)
+			}
+		}
+	}
+}
+
+// texGroupText returns the text of a group, in parentheses if it's more than one symbol.
+func texGroupText(row texRow) string {
+	s := texText(row)
+	if utf8.RuneCountInString(s) > 1 && !(len(row) == 1 && isTeXFenced(row[0])) {
+		return "(" + s + ")"
+	}
+	return s
+}
+
+func isTeXFenced(n texNode) bool {
+	_, ok := n.(texFenced)
+	return ok
+}
+
+// texScriptText returns the text of a script in superscript or subscript characters, or
+// after the given mark if there aren't characters for all of it.
+func texScriptText(row texRow, chars map[rune]rune, mark string) string {
+	s := strings.ReplaceAll(texText(row), " ", "")
+	mapped := []rune(s)
+	for i, r := range mapped {
+		c, ok := chars[r]
+		if !ok {
+			return mark + texGroupText(row)
+		}
+		mapped[i] = c
+	}
+	return string(mapped)
+}
+
+var texSuperscripts = map[rune]rune{
+	'0': '⁰', '1': '¹', '2': '²', '3': '³', '4': '⁴', '5': '⁵', '6': '⁶', '7': '⁷', '8': '⁸', '9': '⁹',
+	'+': '⁺', '−': '⁻', '=': '⁼', '(': '⁽', ')': '⁾', 'n': 'ⁿ', 'i': 'ⁱ', '′': '′',
+}
+
+var texSubscripts = map[rune]rune{
+	'0': '₀', '1': '₁', '2': '₂', '3': '₃', '4': '₄', '5': '₅', '6': '₆', '7': '₇', '8': '₈', '9': '₉',
+	'+': '₊', '−': '₋', '=': '₌', '(': '₍', ')': '₎', 'a': 'ₐ', 'e': 'ₑ', 'o': 'ₒ', 'x': 'ₓ',
+	'i': 'ᵢ', 'j': 'ⱼ', 'k': 'ₖ', 'n': 'ₙ', 'm': 'ₘ',
+}
+
+// texLayout lays out formulas. Every part of one is laid out as a box, and boxes are put
+// together into bigger ones.
+type texLayout struct {
+	shaper text.Shaper
+	color  color.NRGBA
+}
+
+// texBox is a laid out part of a formula. Its ops are drawn from its top left corner, and
+// its baseline is ascent below that.
+type texBox struct {
+	call    op.CallOp
+	width   int
+	ascent  int
+	descent int
+}
+
+func (b texBox) height() int {
+	return b.ascent + b.descent
+}
+
+// place draws the box with its top left corner at the given point.
+func (b texBox) place(ops *op.Ops, x, y int) {
+	defer op.Offset(image.Point{x, y}).Push(ops).Pop()
+	b.call.Add(ops)
+}
+
+// scriptSize returns the size of the scripts (and the parts of fractions) of something of
+// the given size.
+func scriptSize(size unit.Sp) unit.Sp {
+	if size *= 0.75; size < 8 {
+		return 8
+	}
+	return size
+}
+
+func (l *texLayout) lay(gtx C, n texNode, size unit.Sp) texBox {
+	switch n := n.(type) {
+	case texRow:
+		return l.row(gtx, n, size)
+	case texSym:
+		return l.symbol(gtx, n, size)
+	case texFrac:
+		return l.frac(gtx, n, size)
+	case texScripts:
+		return l.scripts(gtx, n, size)
+	case texSqrt:
+		return l.sqrt(gtx, n, size)
+	case texAccent:
+		return l.accent(gtx, n, size)
+	case texFenced:
+		return l.fenced(gtx, n, size)
+	case texMatrix:
+		return l.matrix(gtx, n, size)
+	case texSpace:
+		return texBox{width: int(n.em * float32(gtx.Sp(size)))}
+	}
+	return texBox{}
+}
+
+func (l *texLayout) symbol(gtx C, sym texSym, size unit.Sp) texBox {
+	var fnt text.Font
+	if sym.italic {
+		fnt.Style = text.Italic
+	}
+	if sym.bold {
+		fnt.Weight = text.Bold
+	}
+	if sym.class == texLarge {
+		size *= 1.4
+	}
+	m := op.Record(gtx.Ops)
+	paint.ColorOp{Color: l.color}.Add(gtx.Ops)
+	gtx.Constraints = layout.Constraints{Max: image.Point{1 << 24, 1 << 24}}
+	dims := widget.Label{MaxLines: 1}.Layout(gtx, l.shaper, fnt, size, sym.text)
+	return texBox{
+		call:    m.Stop(),
+		width:   dims.Size.X,
+		ascent:  dims.Size.Y - dims.Baseline,
+		descent: dims.Baseline,
+	}
+}
+
+// row lays out nodes side by side on one baseline, with space around operators and relations
+// like TeX puts there.
+func (l *texLayout) row(gtx C, row texRow, size unit.Sp) texBox {
+	em := gtx.Sp(size)
+	boxes := make([]texBox, len(row))
+	pads := make([][2]int, len(row))
+	var b texBox
+	for i, n := range row {
+		boxes[i] = l.lay(gtx, n, size)
+		class := texOrd
+		switch n := n.(type) {
+		case texSym:
+			class = n.class
+		case texScripts:
+			if sym, ok := n.base.(texSym); ok {
+				class = sym.class
+			}
+		}
+		switch class {
+		case texBin:
+			// An operator at the start is a sign (as in -1), and sets close to what follows.
+			if i > 0 {
+				pads[i] = [2]int{em * 2 / 9, em * 2 / 9}
+			}
+		case texRel:
+			if i > 0 {
+				pads[i][0] = em * 5 / 18
+			}
+			pads[i][1] = em * 5 / 18
+		case texPunct, texFunc, texLarge:
+			pads[i][1] = em / 6
+		}
+		b.width += pads[i][0] + boxes[i].width + pads[i][1]
+		b.ascent = max(b.ascent, boxes[i].ascent)
+		b.descent = max(b.descent, boxes[i].descent)
+	}
+	if n := len(row); n > 0 {
+		b.width -= pads[n-1][1]
+	}
+	m := op.Record(gtx.Ops)
+	x := 0
+	for i, box := range boxes {
+		x += pads[i][0]
+		box.place(gtx.Ops, x, b.ascent-box.ascent)
+		x += box.width + pads[i][1]
+	}
+	b.call = m.Stop()
+	return b
+}
+
+// axis returns how far above the baseline fractions and delimiters are centered.
+func axis(gtx C, size unit.Sp) int {
+	return gtx.Sp(size) / 4
+}
+
+func (l *texLayout) rule(gtx C, x, y, w, h int) {
+	paint.FillShape(gtx.Ops, l.color, clip.Rect{Min: image.Point{x, y}, Max: image.Point{x + w, y + h}}.Op())
+}
+
+func (l *texLayout) frac(gtx C, f texFrac, size unit.Sp) texBox {
+	num := l.row(gtx, f.num, scriptSize(size))
+	den := l.row(gtx, f.den, scriptSize(size))
+	em := gtx.Sp(size)
+	thick, gap := max(1, gtx.Dp(1)), max(1, em/8)
+	if f.noBar {
+		thick = 0
+	}
+	b := texBox{width: max(num.width, den.width) + 2*gap}
+	b.ascent = axis(gtx, size) + thick/2 + gap + num.height()
+	b.descent = max(0, den.height()+gap+thick-thick/2-axis(gtx, size))
+	m := op.Record(gtx.Ops)
+	num.place(gtx.Ops, (b.width-num.width)/2, 0)
+	if thick > 0 {
+		l.rule(gtx, 0, num.height()+gap, b.width, thick)
+	}
+	den.place(gtx.Ops, (b.width-den.width)/2, num.height()+2*gap+thick)
+	b.call = m.Stop()
+	return b
+}
+
+func (l *texLayout) scripts(gtx C, s texScripts, size unit.Sp) texBox {
+	base := l.lay(gtx, s.base, size)
+	var sup, sub texBox
+	if s.sup != nil {
+		sup = l.row(gtx, s.sup, scriptSize(size))
+	}
+	if s.sub != nil {
+		sub = l.row(gtx, s.sub, scriptSize(size))
+	}
+	em := gtx.Sp(size)
+	m := op.Record(gtx.Ops)
+	var b texBox
+	if sym, ok := s.base.(texSym); ok && sym.limits {
+		// The limits of an operator go above and below it.
+		gap := em / 10
+		b.width = max(base.width, max(sup.width, sub.width))
+		b.ascent = base.ascent
+		if s.sup != nil {
+			b.ascent += gap + sup.height()
+		}
+		b.descent = base.descent
+		if s.sub != nil {
+			b.descent += gap + sub.height()
+		}
+		sup.place(gtx.Ops, (b.width-sup.width)/2, 0)
+		base.place(gtx.Ops, (b.width-base.width)/2, b.ascent-base.ascent)
+		sub.place(gtx.Ops, (b.width-sub.width)/2, b.ascent+base.descent+gap)
+		b.call = m.Stop()
+		return b
+	}
+	up, down := em*2/5, em/5
+	if s.sup != nil && s.sub != nil {
+		down = em * 3 / 10
+	}
+	b.width = base.width + max(sup.width, sub.width) + em/20
+	b.ascent = max(base.ascent, up+sup.ascent)
+	b.descent = max(base.descent, down+sub.descent)
+	base.place(gtx.Ops, 0, b.ascent-base.ascent)
+	if s.sup != nil {
+		sup.place(gtx.Ops, base.width, b.ascent-up-sup.ascent)
+	}
+	if s.sub != nil {
+		sub.place(gtx.Ops, base.width, b.ascent+down-sub.ascent)
+	}
+	b.call = m.Stop()
+	return b
+}
+
+func (l *texLayout) sqrt(gtx C, s texSqrt, size unit.Sp) texBox {
+	body := l.row(gtx, s.body, size)
+	em := gtx.Sp(size)
+	thick, gap, hook := max(1, gtx.Dp(1)), max(1, em/10), em/2
+	b := texBox{
+		width:   hook + body.width + gap,
+		ascent:  body.ascent + gap + thick,
+		descent: body.descent,
+	}
+	h, t, fh := float32(b.height()), float32(thick), float32(hook)
+	m := op.Record(gtx.Ops)
+	var p clip.Path
+	p.Begin(gtx.Ops)
+	p.MoveTo(f32.Pt(0, h*0.6))
+	p.LineTo(f32.Pt(fh*0.3, h*0.5))
+	p.LineTo(f32.Pt(fh*0.6, h-t/2))
+	p.LineTo(f32.Pt(fh, t/2))
+	p.LineTo(f32.Pt(float32(b.width), t/2))
+	paint.FillShape(gtx.Ops, l.color, clip.Stroke{Path: p.End(), Width: t}.Op())
+	body.place(gtx.Ops, hook, b.ascent-body.ascent)
+	b.call = m.Stop()
+	return b
+}
+
+func (l *texLayout) accent(gtx C, a texAccent, size unit.Sp) texBox {
+	body := l.row(gtx, a.body, size)
+	m := op.Record(gtx.Ops)
+	b := texBox{width: body.width, descent: body.descent}
+	if a.mark == "" {
+		thick, gap := max(1, gtx.Dp(1)), max(1, gtx.Sp(size)/10)
+		b.ascent = body.ascent + gap + thick
+		l.rule(gtx, 0, 0, b.width, thick)
+	} else {
+		// The marks are near the middle of their boxes, so that's put at the top of the body.
+		mark := l.symbol(gtx, texSym{text: a.mark}, scriptSize(size))
+		b.width = max(b.width, mark.width)
+		b.ascent = body.ascent + mark.height()/2
+		mark.place(gtx.Ops, (b.width-mark.width)/2, 0)
+	}
+	body.place(gtx.Ops, (b.width-body.width)/2, b.ascent-body.ascent)
+	b.call = m.Stop()
+	return b
+}
+
+func (l *texLayout) fenced(gtx C, f texFenced, size unit.Sp) texBox {
+	body := l.lay(gtx, f.body, size)
+	em := gtx.Sp(size)
+	pad, dw := em/10, em*2/5
+	b := texBox{ascent: body.ascent + pad, descent: body.descent + pad}
+	m := op.Record(gtx.Ops)
+	if f.open != "" {
+		l.delimiter(gtx, f.open, 0, dw, b.height())
+		b.width += dw + pad
+	}
+	body.place(gtx.Ops, b.width, pad)
+	b.width += body.width
+	if f.close != "" {
+		b.width += pad
+		l.delimiter(gtx, f.close, b.width, dw, b.height())
+		b.width += dw
+	}
+	b.call = m.Stop()
+	return b
+}
+
+// delimiter draws a delimiter of the given width and height, starting from the given x.
+func (l *texLayout) delimiter(gtx C, delim string, x, w, h int) {
+	mirror := false
+	switch delim {
+	case ")", "]", "}", "⟩":
+		mirror = true
+	}
+	pt := func(fx, fy float32) f32.Point {
+		if mirror {
+			fx = 1 - fx
+		}
+		return f32.Pt(float32(x)+fx*float32(w), fy*float32(h))
+	}
+	var p clip.Path
+	p.Begin(gtx.Ops)
+	switch delim {
+	case "(", ")":
+		p.MoveTo(pt(0.8, 0))
+		p.QuadTo(pt(0.1, 0.5), pt(0.8, 1))
+	case "[", "]":
+		p.MoveTo(pt(0.8, 0))
+		p.LineTo(pt(0.3, 0))
+		p.LineTo(pt(0.3, 1))
+		p.LineTo(pt(0.8, 1))
+	case "{", "}":
+		p.MoveTo(pt(0.85, 0))
+		p.QuadTo(pt(0.45, 0), pt(0.45, 0.15))
+		p.LineTo(pt(0.45, 0.4))
+		p.QuadTo(pt(0.45, 0.5), pt(0.1, 0.5))
+		p.QuadTo(pt(0.45, 0.5), pt(0.45, 0.6))
+		p.LineTo(pt(0.45, 0.85))
+		p.QuadTo(pt(0.45, 1), pt(0.85, 1))
+	case "⟨", "⟩":
+		p.MoveTo(pt(0.8, 0))
+		p.LineTo(pt(0.2, 0.5))
+		p.LineTo(pt(0.8, 1))
+	case "|":
+		p.MoveTo(pt(0.5, 0))
+		p.LineTo(pt(0.5, 1))
+	case "‖":
+		p.MoveTo(pt(0.35, 0))
+		p.LineTo(pt(0.35, 1))
+		p.MoveTo(pt(0.65, 0))
+		p.LineTo(pt(0.65, 1))
+	}
+	width := float32(max(1, gtx.Dp(1)))
+	paint.FillShape(gtx.Ops, l.color, clip.Stroke{Path: p.End(), Width: width}.Op())
+}
+
+// matrix lays out the cells of an environment in a grid that's centered on the axis. The
+// columns of aligned equations are alternately aligned right and left, those of cases are
+// aligned left and those of matrices are centered.
+func (l *texLayout) matrix(gtx C, mat texMatrix, size unit.Sp) texBox {
+	em := gtx.Sp(size)
+	colGap, rowGap := em, em/3
+	aligned := mat.env == "aligned" || mat.env == "align" || mat.env == "align*"
+	if aligned {
+		colGap = 0
+	}
+	var cells [][]texBox
+	var colWidths []int
+	ascents, descents := make([]int, len(mat.rows)), make([]int, len(mat.rows))
+	for i, row := range mat.rows {
+		cells = append(cells, make([]texBox, len(row)))
+		for j, cell := range row {
+			c := l.row(gtx, cell, size)
+			if aligned && j%2 == 1 {
+				// The relation that starts the right side of an equation is spaced as if
+				// the left side came before it.
+				c = l.row(gtx, append(texRow{texRow{}}, cell...), size)
+			}
+			cells[i][j] = c
+			if j == len(colWidths) {
+				colWidths = append(colWidths, 0)
+			}
+			colWidths[j] = max(colWidths[j], c.width)
+			ascents[i] = max(ascents[i], c.ascent)
+			descents[i] = max(descents[i], c.descent)
+		}
+	}
+	var b texBox
+	for j, w := range colWidths {
+		if j > 0 {
+			b.width += colGap
+		}
+		b.width += w
+	}
+	h := 0
+	for i := range mat.rows {
+		if i > 0 {
+			h += rowGap
+		}
+		h += ascents[i] + descents[i]
+	}
+	b.ascent = h/2 + axis(gtx, size)
+	b.descent = h - b.ascent
+	m := op.Record(gtx.Ops)
+	y := 0
+	for i, row := range cells {
+		x := 0
+		for j, c := range row {
+			off := x
+			switch {
+			case aligned && j%2 == 0:
+				off += colWidths[j] - c.width
+			case aligned, mat.env == "cases":
+			default:
+				off += (colWidths[j] - c.width) / 2
+			}
+			c.place(gtx.Ops, off, y+ascents[i]-c.ascent)
+			x += colWidths[j] + colGap
+		}
+		y += ascents[i] + descents[i] + rowGap
+	}
+	b.call = m.Stop()
+	return b
+}
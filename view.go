@@ -23,13 +23,27 @@ type ViewStyle struct {
 }
 
 type Palette struct {
-	Fg         color.NRGBA
-	Bg         color.NRGBA
-	LineNumber color.NRGBA
-	Heading    color.NRGBA
-	ListMarker color.NRGBA
-	BlockQuote color.NRGBA
-	CodeBlock  color.NRGBA
+	Fg            color.NRGBA
+	Bg            color.NRGBA
+	LineNumber    color.NRGBA
+	Heading       color.NRGBA
+	ListMarker    color.NRGBA
+	BlockQuote    color.NRGBA
+	CodeBlock     color.NRGBA
+	CodeKeyword   color.NRGBA
+	CodeString    color.NRGBA
+	CodeComment   color.NRGBA
+	CodeNumber    color.NRGBA
+	Math          color.NRGBA
+	LinkText      color.NRGBA
+	LinkURL       color.NRGBA
+	Table         color.NRGBA
+	Strikethrough color.NRGBA
+	Task          color.NRGBA
+	TaskDone      color.NRGBA
+	Footnote      color.NRGBA
+	FrontMatter   color.NRGBA
+	Selection     color.NRGBA
 }
 
 func (vs ViewStyle) Layout(gtx C) D {
@@ -50,9 +64,31 @@ const (
 	SingleViewDocument
 )
 
+// ScrollSync is which way the scrolling of the editor and the document is kept in sync in
+// the split view.
+type ScrollSync uint8
+
+const (
+	ScrollSyncEditor ScrollSync = iota // the document follows the editor
+	ScrollSyncBoth                     // each follows the other
+	ScrollSyncOff
+)
+
+func (s ScrollSync) String() string {
+	switch s {
+	case ScrollSyncEditor:
+		return "Sync: editor"
+	case ScrollSyncBoth:
+		return "Sync: both"
+	default:
+		return "Sync: off"
+	}
+}
+
 type View struct {
 	Editor   Editor
 	document Document
+	worker   renderWorker // renders the document and builds the outline
 
 	Mode         ViewMode
 	doSplitView  widget.Clickable
@@ -63,19 +99,38 @@ type View struct {
 	dividerDrag  gesture.Drag
 	dividerClick gesture.Click
 
+	ScrollSync  ScrollSync
+	cycleSync   widget.Clickable
+	syncedRow   int // the editor's top line when the scrolling was last synced
+	syncedFirst int // the document's first element when the scrolling was last synced, or -1 if it's yet to settle
+
 	SingleWidget SingleViewWidget
 	showEditor   widget.Clickable
 	showDocument widget.Clickable
+
+	ShowOutline   bool
+	toggleOutline widget.Clickable
+	outline       outlinePane
 }
 
 func (vw *View) Layout(gtx C, th *material.Theme, edFnt text.Font, pal Palette) D {
 	vw.update(gtx)
 	return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
 		layout.Flexed(1, func(gtx C) D {
-			if vw.Mode == ViewModeSingle {
-				return vw.laySingleView(gtx, th, edFnt, pal)
-			}
-			return vw.laySplitView(gtx, th, edFnt, pal)
+			return layout.Flex{}.Layout(gtx,
+				layout.Rigid(func(gtx C) D {
+					if !vw.ShowOutline {
+						return D{}
+					}
+					return vw.layOutline(gtx, th)
+				}),
+				layout.Flexed(1, func(gtx C) D {
+					if vw.Mode == ViewModeSingle {
+						return vw.laySingleView(gtx, th, edFnt, pal)
+					}
+					return vw.laySplitView(gtx, th, edFnt, pal)
+				}),
+			)
 		}),
 		layout.Rigid(func(gtx C) D {
 			return vw.layToolbar(gtx, th)
@@ -83,7 +138,17 @@ func (vw *View) Layout(gtx C, th *material.Theme, edFnt text.Font, pal Palette)
 	)
 }
 
+// useInvalidate sets what's called when a background render has finished.
+func (vw *View) useInvalidate(invalidate func()) {
+	vw.worker.invalidate = invalidate
+}
+
 func (vw *View) update(gtx C) {
+	if res, ok := vw.worker.take(); ok {
+		vw.document.setRender(res.elements, res.err)
+		vw.outline.set(res.outline)
+		vw.Editor.useParse(res.lines, res.marks)
+	}
 	// Update view mode if view mode buttons are clicked.
 	if vw.doSplitView.Clicked() {
 		vw.Mode = ViewModeSplit
@@ -93,6 +158,11 @@ func (vw *View) update(gtx C) {
 		vw.Mode = ViewModeSingle
 		op.InvalidateOp{}.Add(gtx.Ops)
 	}
+	if vw.cycleSync.Clicked() {
+		vw.ScrollSync = (vw.ScrollSync + 1) % (ScrollSyncOff + 1)
+		vw.syncedRow = -1
+		op.InvalidateOp{}.Add(gtx.Ops)
+	}
 	// Update which single view widget to show.
 	if vw.showEditor.Clicked() {
 		vw.SingleWidget = SingleViewEditor
@@ -103,13 +173,40 @@ func (vw *View) update(gtx C) {
 		vw.SingleWidget = SingleViewDocument
 		op.InvalidateOp{}.Add(gtx.Ops)
 	}
+	if vw.toggleOutline.Clicked() {
+		vw.ShowOutline = !vw.ShowOutline
+		op.InvalidateOp{}.Add(gtx.Ops)
+	}
+	// Jump to the heading that was clicked in the outline, in both the editor and the
+	// document.
+	if e, ok := vw.outline.clicked(); ok {
+		vw.Editor.MoveToLine(e.line)
+		vw.Editor.ScrollToLine(e.line)
+		vw.Editor.Focus()
+		vw.document.ScrollToHeading(e.id)
+		op.InvalidateOp{}.Add(gtx.Ops)
+	}
+	if vw.outline.updateTOC.Clicked() {
+		vw.Editor.UpdateTOC()
+		op.InvalidateOp{}.Add(gtx.Ops)
+	}
+	// Jump to the source of the block that was double-clicked in the document.
+	if row, ok := vw.document.SourceClicked(); ok {
+		vw.Editor.MoveToLine(row)
+		vw.Editor.Focus()
+		if vw.Mode == ViewModeSingle {
+			vw.SingleWidget = SingleViewEditor
+		}
+		op.InvalidateOp{}.Add(gtx.Ops)
+	}
+	// Toggle the task items whose checkboxes were clicked in the document.
+	for _, off := range vw.document.TaskClicks() {
+		vw.Editor.toggleTaskAt(off)
+	}
 }
 
 func (vw *View) laySplitView(gtx C, th *material.Theme, edFnt text.Font, pal Palette) D {
-	if vw.Editor.HasChanged() {
-		vw.Editor.highlight()
-		_ = vw.document.Render(vw.Editor.Text(), th)
-	}
+	vw.handleChange(th)
 
 	maxWidth := float32(gtx.Constraints.Max.X)
 	vw.dividerPos = int(vw.SplitRatio * maxWidth)
@@ -125,6 +222,7 @@ func (vw *View) laySplitView(gtx C, th *material.Theme, edFnt text.Font, pal Pal
 	c := m.Stop()
 
 	vw.SplitRatio = float32(vw.dividerPos) / maxWidth
+	defer vw.syncScroll(gtx)
 	return layout.Flex{}.Layout(gtx,
 		layout.Flexed(vw.SplitRatio, func(gtx C) D {
 			return vw.Editor.Layout(gtx, th.Shaper, edFnt, th.TextSize, pal)
@@ -139,6 +237,57 @@ func (vw *View) laySplitView(gtx C, th *material.Theme, edFnt text.Font, pal Pal
 	)
 }
 
+// handleChange has the document re-rendered and the outline rebuilt in the background if the
+// text was changed. Until that's done, they show what they did before.
+func (vw *View) handleChange(th *material.Theme) {
+	if !vw.Editor.HasChanged() {
+		return
+	}
+	vw.Editor.highlight()
+	vw.worker.request(vw.Editor.Text(), th)
+}
+
+// layOutline lays out the outline pane along with a rule on its right.
+func (vw *View) layOutline(gtx C, th *material.Theme) D {
+	width := gtx.Dp(220)
+	gtx.Constraints.Min.X = width
+	gtx.Constraints.Max.X = width
+	current := outlineSection(vw.outline.entries, vw.Editor.CursorLine())
+	return layout.Flex{}.Layout(gtx,
+		layout.Flexed(1, func(gtx C) D {
+			return vw.outline.layout(gtx, th, current)
+		}),
+		layout.Rigid(rule{
+			width: 2,
+			color: merge(th.Fg, th.Bg, 0.7),
+			axis:  layout.Vertical,
+		}.Layout),
+	)
+}
+
+// syncScroll scrolls the document to follow the editor if the editor has scrolled since the
+// last call, and (with ScrollSyncBoth) the editor to follow the document if it's the document
+// that has scrolled.
+func (vw *View) syncScroll(gtx C) {
+	row, first := vw.Editor.TopLine(), vw.document.elemList.Position.First
+	switch {
+	case vw.ScrollSync == ScrollSyncOff:
+		vw.syncedRow = row
+	case row != vw.syncedRow:
+		vw.syncedRow = row
+		vw.document.ScrollToLine(row)
+		// Where the document ends up isn't known until it's laid out again.
+		vw.syncedFirst = -1
+		op.InvalidateOp{}.Add(gtx.Ops)
+		return
+	case vw.ScrollSync == ScrollSyncBoth && vw.syncedFirst != -1 && first != vw.syncedFirst:
+		vw.Editor.ScrollToLine(vw.document.TopLine())
+		vw.syncedRow = vw.Editor.TopLine()
+		op.InvalidateOp{}.Add(gtx.Ops)
+	}
+	vw.syncedFirst = first
+}
+
 func (vw *View) layDivider(gtx C, w layout.Widget) D {
 	var de *pointer.Event
 	for _, e := range vw.dividerDrag.Events(gtx.Metric, gtx, gesture.Horizontal) {
@@ -176,10 +325,7 @@ func (vw *View) laySingleView(gtx C, th *material.Theme, edFnt text.Font, pal Pa
 	if vw.SingleWidget == SingleViewDocument {
 		return vw.document.Layout(gtx, th)
 	}
-	if vw.Editor.HasChanged() {
-		vw.Editor.highlight()
-		_ = vw.document.Render(vw.Editor.Text(), th)
-	}
+	vw.handleChange(th)
 	return vw.Editor.Layout(gtx, th.Shaper, edFnt, th.TextSize, pal)
 }
 
@@ -226,6 +372,31 @@ func (vw *View) layToolbar(gtx C, th *material.Theme) D {
 				})
 			}),
 			layout.Rigid(layout.Spacer{Width: 8}.Layout),
+			layout.Rigid(func(gtx C) D {
+				return buttonGroup{
+					bg:       merge(th.Bg, th.Fg, 0.08),
+					fg:       th.Fg,
+					shaper:   th.Shaper,
+					textSize: th.TextSize,
+				}.layout(gtx, []groupButton{
+					{click: &vw.toggleOutline, icon: iconOutline},
+				})
+			}),
+			layout.Rigid(layout.Spacer{Width: 8}.Layout),
+			layout.Rigid(func(gtx C) D {
+				if vw.Mode != ViewModeSplit {
+					return D{}
+				}
+				return buttonGroup{
+					bg:       merge(th.Bg, th.Fg, 0.08),
+					fg:       th.Fg,
+					shaper:   th.Shaper,
+					textSize: th.TextSize,
+				}.layout(gtx, []groupButton{
+					{click: &vw.cycleSync, text: vw.ScrollSync.String()},
+				})
+			}),
+			layout.Rigid(layout.Spacer{Width: 8}.Layout),
 			layout.Rigid(func(gtx C) D {
 				return buttonGroup{
 					bg:       merge(th.Bg, th.Fg, 0.08),
@@ -23,6 +23,7 @@ var (
 	iconDirectory  = mustIcon(icons.FileFolderOpen)
 	iconEdit       = mustIcon(icons.EditorModeEdit)
 	iconHome       = mustIcon(icons.ActionHome)
+	iconOutline    = mustIcon(icons.EditorFormatListBulleted)
 	iconReader     = mustIcon(icons.ActionChromeReaderMode)
 	iconRegFile    = mustIcon(icons.ActionDescription)
 	iconVisibility = mustIcon(icons.ActionVisibility)